@@ -0,0 +1,96 @@
+package main
+
+import "math"
+
+// ambisonics encodes the sound field at the listener into spherical harmonics
+// up to order, in ACN channel order with SN3D normalisation (the AmbiX
+// convention). Horizontal output keeps only the 2·order+1 components that
+// vary with azimuth alone, for decoding to a ring of speakers.
+type ambisonics struct {
+	order      int
+	horizontal bool
+}
+
+// components returns the degree and index (n, m) of each channel, in ACN
+// order.
+func (a ambisonics) components() [][2]int {
+	var components [][2]int
+	for n := 0; n <= a.order; n++ {
+		for m := -n; m <= n; m++ {
+			if a.horizontal && m != -n && m != n {
+				continue
+			}
+			components = append(components, [2]int{n, m})
+		}
+	}
+	return components
+}
+
+// channels returns the number of output channels.
+func (a ambisonics) channels() int {
+	if a.horizontal {
+		return 2*a.order + 1
+	}
+	return (a.order + 1) * (a.order + 1)
+}
+
+// encode returns the gain of each channel for a plane wave arriving from the
+// given azimuth, anticlockwise from straight ahead, and elevation in radians.
+func (a ambisonics) encode(azimuth, elevation float64) []float64 {
+	components := a.components()
+	gains := make([]float64, len(components))
+	for i, c := range components {
+		gains[i] = sphericalHarmonic(c[0], c[1], azimuth, elevation)
+	}
+	return gains
+}
+
+// sphericalHarmonic returns the SN3D-normalised real spherical harmonic of
+// degree n and index m, without the Condon-Shortley phase.
+func sphericalHarmonic(n, m int, azimuth, elevation float64) float64 {
+	k := m
+	if k < 0 {
+		k = -k
+	}
+	norm := 1.0
+	for i := n - k + 1; i <= n+k; i++ {
+		norm /= float64(i) // (n-|m|)! / (n+|m|)!
+	}
+	if k != 0 {
+		norm *= 2
+	}
+
+	y := math.Sqrt(norm) * legendre(n, k, math.Sin(elevation))
+	if m < 0 {
+		return y * math.Sin(float64(k)*azimuth)
+	}
+	return y * math.Cos(float64(k)*azimuth)
+}
+
+// legendre returns the associated Legendre function P_n^m(x) for 0 ≤ m ≤ n,
+// without the Condon-Shortley phase.
+func legendre(n, m int, x float64) float64 {
+	// P_m^m = (2m-1)!! (1-x²)^(m/2)
+	pmm := 1.0
+	s := math.Sqrt(math.Max(0, 1-x*x))
+	for i := 1; i <= m; i++ {
+		pmm *= float64(2*i-1) * s
+	}
+	if n == m {
+		return pmm
+	}
+
+	// Raise the degree with (n-m) P_n^m = (2n-1) x P_{n-1}^m - (n+m-1) P_{n-2}^m
+	previous, current := pmm, x*float64(2*m+1)*pmm
+	for l := m + 2; l <= n; l++ {
+		previous, current = current, (float64(2*l-1)*x*current-float64(l+m-1)*previous)/float64(l-m)
+	}
+	return current
+}
+
+// pan returns the gain of each channel for sound arriving in the horizontal
+// plane from azimuth, in degrees. The scene is flat, so every path arrives at
+// zero elevation.
+func (a ambisonics) pan(azimuth float64) []float64 {
+	return a.encode(azimuth*math.Pi/180, 0)
+}
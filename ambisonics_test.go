@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestSphericalHarmonicsMatchClosedForms(t *testing.T) {
+	tests := []struct {
+		n, m int
+		want func(azimuth, elevation float64) float64
+	}{
+		{0, 0, func(az, el float64) float64 { return 1 }},
+		{1, -1, func(az, el float64) float64 { return math.Sin(az) * math.Cos(el) }},
+		{1, 0, func(az, el float64) float64 { return math.Sin(el) }},
+		{1, 1, func(az, el float64) float64 { return math.Cos(az) * math.Cos(el) }},
+		{2, -2, func(az, el float64) float64 { return math.Sqrt(3) / 2 * math.Sin(2*az) * math.Pow(math.Cos(el), 2) }},
+		{2, -1, func(az, el float64) float64 { return math.Sqrt(3) / 2 * math.Sin(az) * math.Sin(2*el) }},
+		{2, 0, func(az, el float64) float64 { return 0.5 * (3*math.Pow(math.Sin(el), 2) - 1) }},
+		{2, 2, func(az, el float64) float64 { return math.Sqrt(3) / 2 * math.Cos(2*az) * math.Pow(math.Cos(el), 2) }},
+		{3, -3, func(az, el float64) float64 { return math.Sqrt(5.0/8) * math.Sin(3*az) * math.Pow(math.Cos(el), 3) }},
+		{3, 0, func(az, el float64) float64 { return 0.5 * math.Sin(el) * (5*math.Pow(math.Sin(el), 2) - 3) }},
+	}
+
+	for _, tt := range tests {
+		for _, direction := range [][2]float64{{0, 0}, {0.7, 0}, {-2.1, 0.4}, {2.8, -1.1}} {
+			got := sphericalHarmonic(tt.n, tt.m, direction[0], direction[1])
+			if want := tt.want(direction[0], direction[1]); math.Abs(got-want) > 1e-12 {
+				t.Errorf("Y(%d, %d) at %v = %v, want %v", tt.n, tt.m, direction, got, want)
+			}
+		}
+	}
+}
+
+func TestAmbisonicChannelOrder(t *testing.T) {
+	tests := []struct {
+		format ambisonics
+		want   string
+	}{
+		{ambisonics{order: 1}, "[[0 0] [1 -1] [1 0] [1 1]]"},
+		{ambisonics{order: 2, horizontal: true}, "[[0 0] [1 -1] [1 1] [2 -2] [2 2]]"},
+	}
+	for _, tt := range tests {
+		components := tt.format.components()
+		if got := fmt.Sprint(components); got != tt.want {
+			t.Errorf("%+v components = %s, want %s", tt.format, got, tt.want)
+		}
+		if len(components) != tt.format.channels() {
+			t.Errorf("%+v has %d components but %d channels", tt.format, len(components), tt.format.channels())
+		}
+	}
+}
+
+func TestAmbisonicResponseEncodesDirection(t *testing.T) {
+	g := &Game{atmosphere: standardAtmosphere(), ambisonics: &ambisonics{order: 1}}
+	g.buildSceneIndex()
+	g.listener = Listener{position: Vector{5, 5}, heading: -math.Pi / 2}
+	g.listener.placeEars()
+	// The listener faces -y, so -x is on their left
+	g.audioSources = []AudioSource{{position: Vector{2, 5}}}
+	g.addImageSourcePaths(0)
+
+	responses := g.buildResponses(0)
+	if len(responses) != 4 {
+		t.Fatalf("got %d channels, want 4", len(responses))
+	}
+	var sums [4]float64
+	for ch, response := range responses {
+		for _, sample := range response {
+			sums[ch] += sample
+		}
+	}
+	w, y, z, x := sums[0], sums[1], sums[2], sums[3]
+	if w <= 0 || math.Abs(y/w-1) > 1e-6 || math.Abs(z/w) > 1e-9 || math.Abs(x/w) > 1e-6 {
+		t.Errorf("W, Y, Z, X = %v, %v, %v, %v, want Y = W and no Z or X for a source on the left", w, y, z, x)
+	}
+}
+
+func TestWriteWAVExtensibleForMoreThanTwoChannels(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeWAV(&buf, sampleRate, formatFloat32, [][]float64{{1}, {2}, {3}, {4}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if len(data) != 68+4*4 {
+		t.Fatalf("writeWAV() wrote %d bytes, want %d", len(data), 68+4*4)
+	}
+	if tag, channels := binary.LittleEndian.Uint16(data[20:]), binary.LittleEndian.Uint16(data[22:]); tag != 0xFFFE || channels != 4 {
+		t.Errorf("format tag %#x with %d channels, want 0xfffe with 4", tag, channels)
+	}
+	if string(data[60:64]) != "data" || binary.LittleEndian.Uint32(data[4:]) != uint32(len(data)-8) {
+		t.Errorf("writeWAV() wrote a malformed header %q", data[:68])
+	}
+	if got := math.Float32frombits(binary.LittleEndian.Uint32(data[68+3*4:])); got != 4 {
+		t.Errorf("fourth channel = %v, want 4", got)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import "math"
+
+const (
+	referenceTemperature = 293.15  // K, T0 in ISO 9613-1
+	triplePointWater     = 273.16  // K, T01 in ISO 9613-1
+	referencePressure    = 101.325 // kPa
+)
+
+func standardAtmosphere() Atmosphere {
+	return Atmosphere{temperature: 20, relativeHumidity: 50, pressure: referencePressure}
+}
+
+func (a Atmosphere) kelvin() float64 {
+	return a.temperature + 273.15
+}
+
+// speedOfSound returns the speed of sound in m/s at the atmosphere's
+// temperature.
+func (a Atmosphere) speedOfSound() float64 {
+	return 343.2 * math.Sqrt(a.kelvin()/referenceTemperature)
+}
+
+// absorptionCoefficient returns the pure-tone attenuation coefficient in dB/m
+// at the given frequency, following ISO 9613-1.
+func (a Atmosphere) absorptionCoefficient(frequency float64) float64 {
+	t := a.kelvin()
+	relativePressure := a.pressure / referencePressure
+
+	// Molar concentration of water vapour in percent
+	c := -6.8346*math.Pow(triplePointWater/t, 1.261) + 4.6151
+	h := a.relativeHumidity * math.Pow(10, c) / relativePressure
+
+	// Relaxation frequencies of oxygen and nitrogen
+	frO := relativePressure * (24 + 4.04e4*h*(0.02+h)/(0.391+h))
+	frN := relativePressure * math.Pow(t/referenceTemperature, -0.5) *
+		(9 + 280*h*math.Exp(-4.170*(math.Pow(t/referenceTemperature, -1.0/3)-1)))
+
+	f2 := frequency * frequency
+	return 8.686 * f2 * (1.84e-11/relativePressure*math.Sqrt(t/referenceTemperature) +
+		math.Pow(t/referenceTemperature, -2.5)*
+			(0.01275*math.Exp(-2239.1/t)/(frO+f2/frO)+
+				0.1068*math.Exp(-3352.0/t)/(frN+f2/frN)))
+}
+
+// absorptionCoefficients evaluates absorptionCoefficient at the centre of
+// every octave band.
+func (a Atmosphere) absorptionCoefficients() bands {
+	var coefficients bands
+	for i, frequency := range bandFrequencies {
+		coefficients[i] = a.absorptionCoefficient(frequency)
+	}
+	return coefficients
+}
+
+// airAttenuation returns the per-band energy factor left after travelling the
+// given distance in metres through the atmosphere.
+func (g *Game) airAttenuation(distance float64) bands {
+	var attenuation bands
+	for i, alpha := range g.airAbsorption {
+		attenuation[i] = math.Exp(-alpha * distance * math.Ln10 / 10) // 10^(-αd/10)
+	}
+	return attenuation
+}
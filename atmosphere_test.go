@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAbsorptionCoefficientMatchesISOTable(t *testing.T) {
+	// ISO 9613-1 Table 1, 20 °C, 50 % relative humidity, 101.325 kPa, in dB/km
+	tests := []struct {
+		frequency float64
+		wantDBkm  float64
+	}{
+		{125, 0.442},
+		{250, 1.31},
+		{500, 2.73},
+		{1000, 4.66},
+		{2000, 9.86},
+		{4000, 29.7},
+		{8000, 105},
+	}
+
+	atmosphere := standardAtmosphere()
+	for _, tt := range tests {
+		got := atmosphere.absorptionCoefficient(tt.frequency) * 1000
+		if math.Abs(got-tt.wantDBkm)/tt.wantDBkm > 0.02 {
+			t.Errorf("absorptionCoefficient(%v) = %.3f dB/km, want %.3f dB/km", tt.frequency, got, tt.wantDBkm)
+		}
+	}
+}
+
+func TestSpeedOfSound(t *testing.T) {
+	if got := standardAtmosphere().speedOfSound(); math.Abs(got-343.2) > 1e-9 {
+		t.Errorf("speedOfSound() at 20 °C = %v, want 343.2", got)
+	}
+	cold := Atmosphere{temperature: 0, relativeHumidity: 50, pressure: referencePressure}
+	if got := cold.speedOfSound(); math.Abs(got-331.3) > 0.1 {
+		t.Errorf("speedOfSound() at 0 °C = %v, want about 331.3", got)
+	}
+}
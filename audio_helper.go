@@ -1,195 +1,200 @@
-package main
-
-import (
-	"math"
-)
-
-func distance(a, b Vector) float64 {
-	dx := a.x - b.x
-	dy := a.y - b.y
-	return math.Sqrt(dx*dx + dy*dy)
-}
-func cross(a, b Vector) Vector {
-	return Vector{x: a.x*b.y - a.y*b.x, y: a.y*b.x - a.x*b.y}
-}
-
-func (v Vector) normalize() Vector {
-	length := math.Sqrt(v.x*v.x + v.y*v.y)
-	if length == 0 {
-		return Vector{0, 0}
-	}
-	return Vector{v.x / length, v.y / length}
-}
-
-func (v Vector) length() float64 {
-	return math.Sqrt(v.x*v.x + v.y*v.y)
-}
-func reflect(incident, normal Vector) Vector {
-	dot := incident.x*normal.x + incident.y*normal.y
-	return Vector{incident.x - 2*dot*normal.x, incident.y - 2*dot*normal.y}
-}
-func dot(a, b Vector) float64 {
-	return a.x*b.x + a.y*b.y
-}
-
-// rayWallIntersection computes the intersection of a ray with a wall, given the
-// ray's origin and direction, the wall's start and end points, and the last
-// intersection point of the ray with the wall. If the ray doesn't intersect
-// with the wall, or if the intersection is too close to the last intersection
-// point, returns a vector with Inf values. Otherwise, returns the intersection
-// point.
-func rayWallIntersection(ray Ray, wall Wall, lastIntersection Vector) Vector {
-	x1, y1 := wall.start.x, wall.start.y
-	x2, y2 := wall.end.x, wall.end.y
-	x3, y3 := ray.origin.x, ray.origin.y
-	x4, y4 := ray.origin.x+ray.direction.x*1000, ray.origin.y+ray.direction.y*1000
-
-	den := (x1-x2)*(y3-y4) - (y1-y2)*(x3-x4)
-	if math.Abs(den) < 1e-8 {
-		return Vector{math.Inf(1), math.Inf(1)}
-	}
-
-	t := ((x1-x3)*(y3-y4) - (y1-y3)*(x3-x4)) / den
-	u := -((x1-x2)*(y1-y3) - (y1-y2)*(x1-x3)) / den
-
-	if t >= 0 && t <= 1 && u >= 0 {
-		intersection := Vector{x1 + t*(x2-x1), y1 + t*(y2-y1)}
-		// Check if the intersection is too close to the last intersection
-		if distance(intersection, lastIntersection) < 0.01 { // small tolerance to avoid repeated bounces
-			return Vector{math.Inf(1), math.Inf(1)} // No valid intersection
-		}
-		return intersection
-	}
-
-	return Vector{math.Inf(1), math.Inf(1)}
-}
-
-func extendRayToScreenEdge(ray Ray) Vector {
-	// Calculate intersections with screen edges
-	tTop := -ray.origin.y / ray.direction.y
-	tBottom := (screenHeight - ray.origin.y) / ray.direction.y
-	tLeft := -ray.origin.x / ray.direction.x
-	tRight := (screenWidth - ray.origin.x) / ray.direction.x
-
-	// Find the smallest positive t
-	t := math.Inf(1)
-	if tTop > 0 && tTop < t {
-		t = tTop
-	}
-	if tBottom > 0 && tBottom < t {
-		t = tBottom
-	}
-	if tLeft > 0 && tLeft < t {
-		t = tLeft
-	}
-	if tRight > 0 && tRight < t {
-		t = tRight
-	}
-
-	// Calculate the intersection point
-	return Vector{
-		x: ray.origin.x + t*ray.direction.x,
-		y: ray.origin.y + t*ray.direction.y,
-	}
-}
-
-func distanceFromPointToLine(ray Ray, point Vector) (float64, float64) {
-	lineDir := ray.direction
-
-	// Vector from ray origin to the point
-	v1 := Vector{point.x - ray.origin.x, point.y - ray.origin.y}
-
-	// Perpendicular distance from point to line (same as before)
-	numerator := math.Abs(v1.x*lineDir.y - v1.y*lineDir.x)
-	denominator := math.Sqrt(lineDir.x*lineDir.x + lineDir.y*lineDir.y)
-	perpendicularDist := numerator / denominator
-
-	// Projection of v1 onto the ray direction to get the distance along the ray
-	dotProduct := dot(v1, lineDir)
-
-	if dotProduct < 0 {
-		// If dotProduct < 0, the closest point is behind the ray origin
-		return perpendicularDist, -1
-	}
-
-	closestPoint := Vector{
-		ray.origin.x + dotProduct*lineDir.x,
-		ray.origin.y + dotProduct*lineDir.y,
-	}
-
-	// Distance from the origin to the closest point on the ray
-	distToClosestPoint := math.Sqrt(math.Pow(closestPoint.x-ray.origin.x, 2) + math.Pow(closestPoint.y-ray.origin.y, 2))
-
-	return perpendicularDist, distToClosestPoint
-}
-
-func (g *Game) addAudioPaths(ray Ray, intensity float64) {
-	leftDelay := distance(ray.origin, g.listener.leftEar) / speedOfSound
-	rightDelay := distance(ray.origin, g.listener.rightEar) / speedOfSound
-
-	g.leftPaths = append(g.leftPaths, AudioPath{
-		source:    g.audioSource,
-		delay:     leftDelay,
-		amplitude: intensity,
-		direction: ray.direction,
-	})
-
-	g.rightPaths = append(g.rightPaths, AudioPath{
-		source:    g.audioSource,
-		delay:     rightDelay,
-		amplitude: intensity,
-		direction: ray.direction,
-	})
-}
-func (g *Game) handleDiffraction(ray Ray, wall Wall, edge WallEdge, hitPoint Vector, intensity float64, bounces int) {
-	const (
-		numDiffractedRays = 50  // Increase for smoother wave pattern but worse performance
-		baseIntensity     = 0.1 // Base intensity factor for diffracted rays
-	)
-
-	// Calculate distance to the wall edge
-	distanceToEdge := Vector{hitPoint.x - edge.position.x, hitPoint.y - edge.position.y}.length()
-
-	// Check if the wall allows sound to pass through
-	// Calculate the intensity reduction based on transparency
-	intensity *= (1.0 - wall.properties.transparency)
-
-	if intensity < 0.01 {
-		return
-	}
-
-	for i := 0; i < numDiffractedRays; i++ {
-		t := float64(i) / float64(numDiffractedRays-1)
-		angle := normalizeAngle(math.Atan2(ray.direction.y, ray.direction.x) + (t-0.5)*math.Pi)
-
-		// Calculate intensity considering wall absorption
-		diffractedIntensity := intensity * baseIntensity * (1.0 - wall.properties.absorption)
-
-		// Add distance-based attenuation
-		distanceAttenuation := 1.0 / (1.0 + math.Pow(distanceToEdge, 0.5))
-		diffractedIntensity *= distanceAttenuation
-
-		if diffractedIntensity > 0.01 {
-			newDirection := Vector{math.Cos(angle), math.Sin(angle)}.normalize()
-			diffractedRay := Ray{origin: hitPoint, direction: newDirection}
-			newRayIndex := len(g.rayPathPoints)
-			g.rayPathPoints = append(g.rayPathPoints, []RayPathPoint{{hitPoint, diffractedIntensity}})
-			g.traceRay(diffractedRay, diffractedIntensity, bounces-1, newRayIndex)
-		}
-	}
-	return
-
-}
-
-func normalizeAngle(angle float64) float64 {
-	// First bring into range [0, 2π]
-	angle = math.Mod(angle, 2*math.Pi)
-	if angle < 0 {
-		angle += 2 * math.Pi
-	}
-	// Then shift to [-π, π]
-	if angle > math.Pi {
-		angle -= 2 * math.Pi
-	}
-	return angle
-}
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+func distance(a, b Vector) float64 {
+	dx := a.x - b.x
+	dy := a.y - b.y
+	return math.Sqrt(dx*dx + dy*dy)
+}
+func cross(a, b Vector) Vector {
+	return Vector{x: a.x*b.y - a.y*b.x, y: a.y*b.x - a.x*b.y}
+}
+
+func (v Vector) normalize() Vector {
+	length := math.Sqrt(v.x*v.x + v.y*v.y)
+	if length == 0 {
+		return Vector{0, 0}
+	}
+	return Vector{v.x / length, v.y / length}
+}
+
+func (v Vector) length() float64 {
+	return math.Sqrt(v.x*v.x + v.y*v.y)
+}
+func reflect(incident, normal Vector) Vector {
+	dot := incident.x*normal.x + incident.y*normal.y
+	return Vector{incident.x - 2*dot*normal.x, incident.y - 2*dot*normal.y}
+}
+func dot(a, b Vector) float64 {
+	return a.x*b.x + a.y*b.y
+}
+
+// faceAgainst flips the normal if needed so that it points back towards where
+// a ray travelling in direction came from.
+func faceAgainst(normal, direction Vector) Vector {
+	if dot(normal, direction) > 0 {
+		return Vector{-normal.x, -normal.y}
+	}
+	return normal
+}
+
+// lambertDirection samples a direction from the 2D Lambert (cosine-weighted)
+// distribution around the given unit normal.
+func lambertDirection(normal Vector) Vector {
+	theta := math.Asin(2*rand.Float64() - 1)
+	cos, sin := math.Cos(theta), math.Sin(theta)
+	return Vector{normal.x*cos - normal.y*sin, normal.x*sin + normal.y*cos}
+}
+
+// rayWallIntersection computes the intersection of a ray with a wall, given the
+// ray's origin and direction, the wall's start and end points, and the last
+// intersection point of the ray with the wall. If the ray doesn't intersect
+// with the wall, or if the intersection is too close to the last intersection
+// point, returns a vector with Inf values. Otherwise, returns the intersection
+// point.
+func rayWallIntersection(ray Ray, wall Wall, lastIntersection Vector) Vector {
+	x1, y1 := wall.start.x, wall.start.y
+	x2, y2 := wall.end.x, wall.end.y
+	x3, y3 := ray.origin.x, ray.origin.y
+	x4, y4 := ray.origin.x+ray.direction.x*1000, ray.origin.y+ray.direction.y*1000
+
+	den := (x1-x2)*(y3-y4) - (y1-y2)*(x3-x4)
+	if math.Abs(den) < 1e-8 {
+		return Vector{math.Inf(1), math.Inf(1)}
+	}
+
+	t := ((x1-x3)*(y3-y4) - (y1-y3)*(x3-x4)) / den
+	u := -((x1-x2)*(y1-y3) - (y1-y2)*(x1-x3)) / den
+
+	if t >= 0 && t <= 1 && u >= 0 {
+		intersection := Vector{x1 + t*(x2-x1), y1 + t*(y2-y1)}
+		// Check if the intersection is too close to the last intersection
+		if distance(intersection, lastIntersection) < 0.01 { // small tolerance to avoid repeated bounces
+			return Vector{math.Inf(1), math.Inf(1)} // No valid intersection
+		}
+		return intersection
+	}
+
+	return Vector{math.Inf(1), math.Inf(1)}
+}
+
+// extendRayToEdge returns where the ray, starting inside bounds, leaves them.
+func extendRayToEdge(ray Ray, bounds aabb) Vector {
+	// Calculate intersections with the edges
+	tTop := (bounds.min.y - ray.origin.y) / ray.direction.y
+	tBottom := (bounds.max.y - ray.origin.y) / ray.direction.y
+	tLeft := (bounds.min.x - ray.origin.x) / ray.direction.x
+	tRight := (bounds.max.x - ray.origin.x) / ray.direction.x
+
+	// Find the smallest positive t
+	t := math.Inf(1)
+	if tTop > 0 && tTop < t {
+		t = tTop
+	}
+	if tBottom > 0 && tBottom < t {
+		t = tBottom
+	}
+	if tLeft > 0 && tLeft < t {
+		t = tLeft
+	}
+	if tRight > 0 && tRight < t {
+		t = tRight
+	}
+
+	// Calculate the intersection point
+	return Vector{
+		x: ray.origin.x + t*ray.direction.x,
+		y: ray.origin.y + t*ray.direction.y,
+	}
+}
+
+func distanceFromPointToLine(ray Ray, point Vector) (float64, float64) {
+	lineDir := ray.direction
+
+	// Vector from ray origin to the point
+	v1 := Vector{point.x - ray.origin.x, point.y - ray.origin.y}
+
+	// Perpendicular distance from point to line (same as before)
+	numerator := math.Abs(v1.x*lineDir.y - v1.y*lineDir.x)
+	denominator := math.Sqrt(lineDir.x*lineDir.x + lineDir.y*lineDir.y)
+	perpendicularDist := numerator / denominator
+
+	// Projection of v1 onto the ray direction to get the distance along the ray
+	dotProduct := dot(v1, lineDir)
+
+	if dotProduct < 0 {
+		// If dotProduct < 0, the closest point is behind the ray origin
+		return perpendicularDist, -1
+	}
+
+	closestPoint := Vector{
+		ray.origin.x + dotProduct*lineDir.x,
+		ray.origin.y + dotProduct*lineDir.y,
+	}
+
+	// Distance from the origin to the closest point on the ray
+	distToClosestPoint := math.Sqrt(math.Pow(closestPoint.x-ray.origin.x, 2) + math.Pow(closestPoint.y-ray.origin.y, 2))
+
+	return perpendicularDist, distToClosestPoint
+}
+
+// spreadingLoss returns the energy factor of spherical spreading over the
+// given path length, relative to referenceDistance.
+func spreadingLoss(pathLength float64) float64 {
+	r := referenceDistance / math.Max(pathLength, receiverRadius)
+	return r * r
+}
+
+// detectAtEars records an AudioPath for every ear whose receiver circle the
+// ray passes through before travelling segmentLength.
+func (g *Game) detectAtEars(task rayTask, intensity bands, segmentLength float64) {
+	if g.coveredByImageSources(task) {
+		return
+	}
+	g.leftPaths = g.detectAtReceiver(g.leftPaths, g.listener.leftEar, task, intensity, segmentLength)
+	g.rightPaths = g.detectAtReceiver(g.rightPaths, g.listener.rightEar, task, intensity, segmentLength)
+}
+
+// detectAtReceiver appends an AudioPath to paths if the ray crosses the
+// receiver circle of radius receiverRadius around the given point.
+//
+// Each crossing is weighted by its chord length over the circle's area, which
+// turns the hits of numRays rays into an unbiased estimate of the energy
+// density at the receiver independent of ray count and receiver size. In 2D
+// that density falls off as 1/r, so one more factor of the path length brings
+// it to the spherical spreading of spreadingLoss.
+func (g *Game) detectAtReceiver(paths []AudioPath, receiver Vector, task rayTask, intensity bands, segmentLength float64) []AudioPath {
+	perpendicularDist, along := distanceFromPointToLine(task.ray, receiver)
+	if along == -1 || along > segmentLength || perpendicularDist >= receiverRadius {
+		return paths
+	}
+
+	chord := 2 * math.Sqrt(receiverRadius*receiverRadius-perpendicularDist*perpendicularDist)
+	pathLength := task.travelled + along
+	density := chord / (math.Pi * receiverRadius * receiverRadius) / numRays
+	weight := density * 2 * math.Pi * referenceDistance * referenceDistance / math.Max(pathLength, receiverRadius)
+
+	return append(paths, AudioPath{
+		source:    task.source,
+		delay:     pathLength / g.atmosphere.speedOfSound(),
+		energy:    intensity.mul(g.airAttenuation(along)).scale(weight),
+		direction: Vector{-task.ray.direction.x, -task.ray.direction.y},
+	})
+}
+
+func normalizeAngle(angle float64) float64 {
+	// First bring into range [0, 2π]
+	angle = math.Mod(angle, 2*math.Pi)
+	if angle < 0 {
+		angle += 2 * math.Pi
+	}
+	// Then shift to [-π, π]
+	if angle > math.Pi {
+		angle -= 2 * math.Pi
+	}
+	return angle
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// audioStream renders the mixer's output channels on its own goroutine,
+// passes them through the master bus, converts them to the output format's
+// channels and rate, and queues them in a ring buffer, which the audio device
+// drains through Read. The producer keeps the buffer filled to the target
+// latency and waits while it is full, and Read plays silence for whatever the
+// buffer can't supply.
+type audioStream struct {
+	renderer  *mixer
+	master    *masterBus
+	format    outputFormat
+	resampler *resampler // nil when the output is at sampleRate
+	ring      *ringBuffer
+	target    int // frames to keep buffered, at the output rate
+	period    time.Duration
+	underruns atomic.Uint64 // frames Read had to fill with silence
+	stop      chan struct{}
+	done      chan struct{}
+	samples   []float32
+}
+
+// newAudioStream starts rendering as many channels as the master bus takes,
+// for a sink that takes the given format, with about latency worth of audio
+// buffered.
+func newAudioStream(renderer *mixer, master *masterBus, format outputFormat, latency time.Duration) *audioStream {
+	s := &audioStream{
+		renderer: renderer,
+		master:   master,
+		format:   format,
+		period:   max(time.Millisecond, latency/4),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	block := convolutionBlockSize
+	if format.rate != sampleRate {
+		s.resampler = newResampler(sampleRate, format.rate, format.channels)
+		block = s.resampler.maxOutput(convolutionBlockSize)
+	}
+	s.target = max(block, format.frames(latency))
+	s.ring = newRingBuffer(2*s.target, format.channels)
+	go s.produce()
+	return s
+}
+
+// produce tops the ring buffer up to the target on every tick of its own
+// clock until the stream is closed.
+func (s *audioStream) produce() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.period)
+	defer ticker.Stop()
+
+	channels := make([][]float64, s.master.channels())
+	for ch := range channels {
+		channels[ch] = make([]float64, convolutionBlockSize)
+	}
+	mixed := channels
+	if s.format.channels != len(channels) {
+		mixed = [][]float64{make([]float64, convolutionBlockSize)}
+	}
+	block := convolutionBlockSize
+	if s.resampler != nil {
+		block = s.resampler.maxOutput(convolutionBlockSize)
+	}
+	for {
+		for s.ring.fill()+block <= s.target {
+			s.renderer.render(channels...)
+			s.master.process(channels)
+			if len(mixed) != len(channels) {
+				remix(channels, mixed)
+			}
+			if s.resampler != nil {
+				s.ring.write(s.resampler.process(mixed)...)
+			} else {
+				s.ring.write(mixed...)
+			}
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// close stops the producer and waits for it to finish.
+func (s *audioStream) close() {
+	close(s.stop)
+	<-s.done
+}
+
+// latency returns how much audio is buffered.
+func (s *audioStream) latency() time.Duration {
+	return time.Duration(float64(s.ring.fill()) / float64(s.format.rate) * float64(time.Second))
+}
+
+// Read implements io.Reader for the audio device. It fills buf with
+// interleaved frames in the output format from the ring buffer, and with
+// silence on an underrun.
+func (s *audioStream) Read(buf []byte) (int, error) {
+	count := len(buf) / s.format.frameBytes()
+	if cap(s.samples) < count*s.ring.channels {
+		s.samples = make([]float32, count*s.ring.channels)
+	}
+	samples := s.samples[:count*s.ring.channels]
+	n := s.ring.read(samples)
+	if n < count {
+		clear(samples[n*s.ring.channels:])
+		s.underruns.Add(uint64(count - n))
+	}
+
+	size := s.format.sample.bytes()
+	for i, sample := range samples {
+		s.format.sample.put(buf[i*size:], float64(sample))
+	}
+	return len(samples) * size, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import "math"
+
+const numBands = 7
+
+// bandFrequencies are the centre frequencies of the octave bands that
+// materials and ray energies are defined in.
+var bandFrequencies = [numBands]float64{125, 250, 500, 1000, 2000, 4000, 8000}
+
+// bands holds one value per octave band, e.g. a material coefficient or the
+// energy carried by a ray.
+type bands [numBands]float64
+
+func uniformBands(value float64) bands {
+	var b bands
+	for i := range b {
+		b[i] = value
+	}
+	return b
+}
+
+func (b bands) scale(factor float64) bands {
+	for i := range b {
+		b[i] *= factor
+	}
+	return b
+}
+
+func (b bands) mul(other bands) bands {
+	for i := range b {
+		b[i] *= other[i]
+	}
+	return b
+}
+
+// complement returns 1-b per band, e.g. the reflected share of an absorption
+// coefficient.
+func (b bands) complement() bands {
+	for i := range b {
+		b[i] = 1.0 - b[i]
+	}
+	return b
+}
+
+func (b bands) sqrt() bands {
+	for i := range b {
+		b[i] = math.Sqrt(b[i])
+	}
+	return b
+}
+
+func (b bands) max() float64 {
+	m := b[0]
+	for _, v := range b[1:] {
+		m = math.Max(m, v)
+	}
+	return m
+}
+
+func (b bands) mean() float64 {
+	sum := 0.0
+	for _, v := range b {
+		sum += v
+	}
+	return sum / numBands
+}
+
+// at interpolates the band values linearly over log frequency. Frequencies
+// outside the covered range take the value of the nearest band.
+func (b bands) at(frequency float64) float64 {
+	if frequency <= bandFrequencies[0] {
+		return b[0]
+	}
+	if frequency >= bandFrequencies[numBands-1] {
+		return b[numBands-1]
+	}
+	position := math.Log2(frequency / bandFrequencies[0])
+	i := int(position)
+	t := position - float64(i)
+	return b[i]*(1-t) + b[i+1]*t
+}
+
+// Octave-band properties of the wall materials used in the scenes. Absorption
+// values follow the usual published tables; transparency is exaggerated so
+// that transmission stays audible in the demo.
+var (
+	plasterWall = WallProperties{
+		absorption:            bands{0.15, 0.12, 0.10, 0.08, 0.07, 0.06, 0.06},
+		transparency:          bands{0.30, 0.25, 0.20, 0.15, 0.10, 0.08, 0.06},
+		transmissionRoughness: 0.5,
+		roughness:             0.5,
+	}
+	curtainPartition = WallProperties{
+		absorption:            bands{0.07, 0.31, 0.49, 0.75, 0.70, 0.60, 0.60},
+		transparency:          bands{0.70, 0.60, 0.50, 0.40, 0.30, 0.25, 0.20},
+		transmissionRoughness: 0.5,
+		roughness:             0.5,
+	}
+)
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBandsArithmetic(t *testing.T) {
+	a := bands{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7}
+	b := bands{2, 2, 2, 1, 0.5, 0.5, 0}
+
+	tests := []struct {
+		name string
+		got  bands
+		want bands
+	}{
+		{name: "scale", got: a.scale(2), want: bands{0.2, 0.4, 0.6, 0.8, 1.0, 1.2, 1.4}},
+		{name: "scale by zero", got: a.scale(0), want: bands{}},
+		{name: "mul", got: a.mul(b), want: bands{0.2, 0.4, 0.6, 0.4, 0.25, 0.3, 0}},
+		{name: "mul by uniform", got: a.mul(uniformBands(1)), want: a},
+		{name: "complement", got: a.complement(), want: bands{0.9, 0.8, 0.7, 0.6, 0.5, 0.4, 0.3}},
+		{name: "complement twice", got: a.complement().complement(), want: a},
+		{name: "sqrt", got: bands{0, 0.01, 0.04, 0.25, 1, 4, 9}.sqrt(), want: bands{0, 0.1, 0.2, 0.5, 1, 2, 3}},
+		{name: "uniform", got: uniformBands(0.25), want: bands{0.25, 0.25, 0.25, 0.25, 0.25, 0.25, 0.25}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.want {
+				if math.Abs(tt.got[i]-tt.want[i]) > 1e-12 {
+					t.Errorf("got %v, want %v", tt.got, tt.want)
+					break
+				}
+			}
+		})
+	}
+
+	if a != (bands{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7}) {
+		t.Errorf("operations changed their receiver to %v", a)
+	}
+}
+
+func TestBandsMaxAndMean(t *testing.T) {
+	tests := []struct {
+		name     string
+		b        bands
+		wantMax  float64
+		wantMean float64
+	}{
+		{name: "uniform", b: uniformBands(0.3), wantMax: 0.3, wantMean: 0.3},
+		{name: "peak in the first band", b: bands{7, 0, 0, 0, 0, 0, 0}, wantMax: 7, wantMean: 1},
+		{name: "peak in the last band", b: bands{0, 0, 0, 0, 0, 0, 7}, wantMax: 7, wantMean: 1},
+		{name: "rising", b: bands{1, 2, 3, 4, 5, 6, 7}, wantMax: 7, wantMean: 4},
+		{name: "negative", b: bands{-1, -2, -3, -4, -5, -6, -7}, wantMax: -1, wantMean: -4},
+		{name: "zero", b: bands{}, wantMax: 0, wantMean: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.b.max(); got != tt.wantMax {
+				t.Errorf("max() = %v, want %v", got, tt.wantMax)
+			}
+			if got := tt.b.mean(); math.Abs(got-tt.wantMean) > 1e-12 {
+				t.Errorf("mean() = %v, want %v", got, tt.wantMean)
+			}
+		})
+	}
+}
+
+func TestBandsAt(t *testing.T) {
+	b := bands{1, 2, 3, 4, 5, 6, 7}
+	tests := []struct {
+		name      string
+		frequency float64
+		want      float64
+	}{
+		{name: "first centre", frequency: 125, want: 1},
+		{name: "inner centre", frequency: 1000, want: 4},
+		{name: "last centre", frequency: 8000, want: 7},
+		{name: "halfway in log frequency", frequency: 1000 * math.Sqrt2, want: 4.5},
+		{name: "quarter of an octave up", frequency: 250 * math.Pow(2, 0.25), want: 2.25},
+		{name: "below the range", frequency: 20, want: 1},
+		{name: "above the range", frequency: 20000, want: 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := b.at(tt.frequency); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("at(%v) = %v, want %v", tt.frequency, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaterialBands(t *testing.T) {
+	tests := []struct {
+		name            string
+		properties      WallProperties
+		absorptionRises bool // from the lowest to the highest band
+	}{
+		{name: "plaster", properties: plasterWall},
+		{name: "curtain", properties: curtainPartition, absorptionRises: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.properties
+			for b := 0; b < numBands; b++ {
+				if p.absorption[b] < 0 || p.absorption[b] > 1 || p.transparency[b] < 0 || p.transparency[b] >= 1 {
+					t.Errorf("band %d: absorption %v and transparency %v, want both in [0, 1)", b, p.absorption[b], p.transparency[b])
+				}
+				// The wall absorbs from what it doesn't let through
+				reflected := (1 - p.transparency[b]) * (1 - p.absorption[b])
+				if leaving := reflected + p.transparency[b]; leaving > 1 || reflected <= 0 {
+					t.Errorf("band %d: reflects %v and transmits %v", b, reflected, p.transparency[b])
+				}
+				// Walls let less through the higher the frequency
+				if b > 0 && p.transparency[b] > p.transparency[b-1] {
+					t.Errorf("band %d: transparency rises from %v to %v", b, p.transparency[b-1], p.transparency[b])
+				}
+			}
+			if tt.absorptionRises && p.absorption[numBands-1] <= p.absorption[0] {
+				t.Errorf("absorption falls from %v to %v", p.absorption[0], p.absorption[numBands-1])
+			}
+			if p.roughness < 0 || p.roughness > 1 || p.transmissionRoughness < 0 || p.transmissionRoughness > 1 {
+				t.Errorf("roughness %v and transmission roughness %v, want both in [0, 1]", p.roughness, p.transmissionRoughness)
+			}
+		})
+	}
+}
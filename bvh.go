@@ -0,0 +1,216 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+const bvhLeafSize = 4
+
+// aabb is an axis-aligned bounding box in scene coordinates.
+type aabb struct {
+	min, max Vector
+}
+
+func segmentBounds(a, b Vector) aabb {
+	return aabb{
+		min: Vector{math.Min(a.x, b.x), math.Min(a.y, b.y)},
+		max: Vector{math.Max(a.x, b.x), math.Max(a.y, b.y)},
+	}
+}
+
+func (b aabb) union(other aabb) aabb {
+	return aabb{
+		min: Vector{math.Min(b.min.x, other.min.x), math.Min(b.min.y, other.min.y)},
+		max: Vector{math.Max(b.max.x, other.max.x), math.Max(b.max.y, other.max.y)},
+	}
+}
+
+func (b aabb) centroid() Vector {
+	return Vector{(b.min.x + b.max.x) / 2, (b.min.y + b.max.y) / 2}
+}
+
+// rayEntry returns the ray parameter at which the ray enters the box, using
+// the slab method. The second return value is false if the ray misses the box
+// or only reaches it beyond maxT.
+func (b aabb) rayEntry(ray Ray, maxT float64) (float64, bool) {
+	tMin, tMax := 0.0, maxT
+
+	for _, axis := range [2][4]float64{
+		{ray.origin.x, ray.direction.x, b.min.x, b.max.x},
+		{ray.origin.y, ray.direction.y, b.min.y, b.max.y},
+	} {
+		origin, dir, lo, hi := axis[0], axis[1], axis[2], axis[3]
+		if math.Abs(dir) < 1e-12 {
+			if origin < lo || origin > hi {
+				return 0, false
+			}
+			continue
+		}
+		t1 := (lo - origin) / dir
+		t2 := (hi - origin) / dir
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		tMin = math.Max(tMin, t1)
+		tMax = math.Min(tMax, t2)
+		if tMin > tMax {
+			return 0, false
+		}
+	}
+	return tMin, true
+}
+
+type bvhNode struct {
+	bounds      aabb
+	left, right int // child node indices, -1 for leaves
+	start, end  int // range into bvh.items for leaves
+}
+
+// bvh is a bounding volume hierarchy over a set of primitives identified by
+// their index in the slice of boxes it was built from.
+type bvh struct {
+	nodes []bvhNode
+	items []int
+}
+
+// buildBVH builds a hierarchy over the given boxes by recursively splitting at
+// the median centroid along the longest axis.
+func buildBVH(boxes []aabb) *bvh {
+	b := &bvh{items: make([]int, len(boxes))}
+	for i := range b.items {
+		b.items[i] = i
+	}
+	if len(boxes) > 0 {
+		b.build(boxes, 0, len(boxes))
+	}
+	return b
+}
+
+func (b *bvh) build(boxes []aabb, start, end int) int {
+	bounds := boxes[b.items[start]]
+	centroids := segmentBounds(bounds.centroid(), bounds.centroid())
+	for _, item := range b.items[start+1 : end] {
+		bounds = bounds.union(boxes[item])
+		centroids = centroids.union(segmentBounds(boxes[item].centroid(), boxes[item].centroid()))
+	}
+
+	index := len(b.nodes)
+	b.nodes = append(b.nodes, bvhNode{bounds: bounds, left: -1, right: -1, start: start, end: end})
+	if end-start <= bvhLeafSize {
+		return index
+	}
+
+	splitOnX := centroids.max.x-centroids.min.x >= centroids.max.y-centroids.min.y
+	items := b.items[start:end]
+	sort.Slice(items, func(i, j int) bool {
+		ci, cj := boxes[items[i]].centroid(), boxes[items[j]].centroid()
+		if splitOnX {
+			return ci.x < cj.x
+		}
+		return ci.y < cj.y
+	})
+
+	mid := (start + end) / 2
+	left := b.build(boxes, start, mid)
+	right := b.build(boxes, mid, end)
+	b.nodes[index].left = left
+	b.nodes[index].right = right
+	return index
+}
+
+// closestHit walks the hierarchy front to back and returns the result of hit
+// with the smallest ray parameter. hit reports the ray parameter at which the
+// ray intersects the given primitive, or false if it doesn't.
+func (b *bvh) closestHit(ray Ray, hit func(item int) (float64, bool)) (int, float64) {
+	best, bestT := -1, math.Inf(1)
+	if len(b.nodes) == 0 {
+		return best, bestT
+	}
+
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := b.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+
+		if _, ok := node.bounds.rayEntry(ray, bestT); !ok {
+			continue
+		}
+		if node.left == -1 {
+			for _, item := range b.items[node.start:node.end] {
+				if t, ok := hit(item); ok && (t < bestT || (t == bestT && item < best)) {
+					best, bestT = item, t
+				}
+			}
+			continue
+		}
+
+		// Push the farther child first so the nearer one is visited first and
+		// tightens bestT early.
+		leftT, leftOk := b.nodes[node.left].bounds.rayEntry(ray, bestT)
+		rightT, rightOk := b.nodes[node.right].bounds.rayEntry(ray, bestT)
+		switch {
+		case leftOk && rightOk && leftT <= rightT:
+			stack = append(stack, node.right, node.left)
+		case leftOk && rightOk:
+			stack = append(stack, node.left, node.right)
+		case leftOk:
+			stack = append(stack, node.left)
+		case rightOk:
+			stack = append(stack, node.right)
+		}
+	}
+	return best, bestT
+}
+
+// alongRay calls visit for every primitive whose box the ray passes through
+// before maxT.
+func (b *bvh) alongRay(ray Ray, maxT float64, visit func(item int)) {
+	if len(b.nodes) == 0 {
+		return
+	}
+
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := b.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+
+		if _, ok := node.bounds.rayEntry(ray, maxT); !ok {
+			continue
+		}
+		if node.left == -1 {
+			for _, item := range b.items[node.start:node.end] {
+				visit(item)
+			}
+			continue
+		}
+		stack = append(stack, node.left, node.right)
+	}
+}
+
+// buildSceneIndex rebuilds the acceleration structure over g.walls. It must
+// be called whenever the scene geometry changes.
+func (g *Game) buildSceneIndex() {
+	wallBoxes := make([]aabb, len(g.walls))
+	for i, wall := range g.walls {
+		wallBoxes[i] = segmentBounds(wall.start, wall.end)
+	}
+	g.wallIndex = buildBVH(wallBoxes)
+}
+
+// closestWallHit returns the closest intersection of the ray with any wall
+// and the index of that wall, or -1 if the ray hits nothing.
+func (g *Game) closestWallHit(ray Ray, lastIntersection Vector) (Vector, int) {
+	dirLength := ray.direction.length()
+	wall, _ := g.wallIndex.closestHit(ray, func(i int) (float64, bool) {
+		intersection := rayWallIntersection(ray, g.walls[i], lastIntersection)
+		if math.IsInf(intersection.x, 1) || math.IsInf(intersection.y, 1) {
+			return 0, false
+		}
+		return distance(ray.origin, intersection) / dirLength, true
+	})
+	if wall == -1 {
+		return Vector{math.Inf(1), math.Inf(1)}, -1
+	}
+	return rayWallIntersection(ray, g.walls[wall], lastIntersection), wall
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func randomScene(rng *rand.Rand, numWalls int) *Game {
+	g := &Game{}
+	for i := 0; i < numWalls; i++ {
+		start := Vector{rng.Float64() * screenWidth, rng.Float64() * screenHeight}
+		end := Vector{start.x + (rng.Float64()-0.5)*400, start.y + (rng.Float64()-0.5)*400}
+		g.walls = append(g.walls, Wall{start: start, end: end})
+	}
+	g.getWallEdges()
+	g.buildSceneIndex()
+	return g
+}
+
+func TestClosestWallHitMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	g := randomScene(rng, 200)
+
+	for i := 0; i < 1000; i++ {
+		angle := rng.Float64() * 2 * math.Pi
+		ray := Ray{
+			origin:    Vector{rng.Float64() * screenWidth, rng.Float64() * screenHeight},
+			direction: Vector{math.Cos(angle), math.Sin(angle)},
+		}
+		last := Vector{math.Inf(1), math.Inf(1)}
+
+		wantWall := -1
+		minDist := math.Inf(1)
+		for j, wall := range g.walls {
+			intersection := rayWallIntersection(ray, wall, last)
+			if intersection.x != math.Inf(1) && intersection.y != math.Inf(1) {
+				if dist := distance(ray.origin, intersection); dist < minDist {
+					minDist = dist
+					wantWall = j
+				}
+			}
+		}
+
+		if _, gotWall := g.closestWallHit(ray, last); gotWall != wantWall {
+			t.Fatalf("ray %d: closestWallHit() got wall %d, want %d", i, gotWall, wantWall)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// Number of samples in each partition of the impulse response, which is also
+// the number of samples the convolver renders at a time.
+const convolutionBlockSize = 512
+
+// convolver renders a dry signal through an impulse response per output
+// channel with uniformly partitioned overlap-save convolution. Each impulse
+// response is cut into blocks of convolutionBlockSize samples whose spectra
+// are multiplied with a frequency-domain delay line holding the spectra of
+// recent input blocks.
+//
+// Channels share complex FFTs in pairs: the first response of a pair (the left
+// ear, for a binaural response) goes in the real part and the second one in
+// the imaginary part. Since both outputs are real, the inverse transform
+// returns them in the real and imaginary parts as well.
+//
+// When the impulse response changes, the input is convolved with both the old
+// and the new response for crossfade samples and the outputs are faded into
+// each other. A response that arrives during a fade waits for it to finish,
+// and only the latest one waiting is kept.
+//
+// Paths that should shift in pitch as they lengthen or shorten bypass the
+// impulse response and are rendered from the same input by moving delay taps,
+// see dopplerLine.
+//
+// render must only be called from one goroutine at a time. New responses are
+// handed over from any other goroutine through incoming without locking, and
+// picked up at the start of the next block.
+type convolver struct {
+	incoming   atomic.Pointer[partitionedResponse]
+	dry        signal
+	doppler    dopplerLine
+	partitions partitionedResponse
+	previous   partitionedResponse // partitions being faded out, nil when not fading
+	pending    partitionedResponse // partitions waiting for the fade to finish
+	crossfade  int
+	faded      int            // samples of the current fade rendered so far
+	history    [][]complex128 // spectra of past input blocks, newest at head
+	head       int
+	input      []float64 // the last two blocks of dry input
+	spectrum   []complex128
+	fadeOut    []complex128
+	twiddles   []complex128 // of the transforms of two blocks
+	output     [][]float64  // rendered output per channel not yet handed out
+	position   int
+}
+
+// partitionedResponse holds, for each pair of channels, the spectra of the
+// first channel + i·second channel per block.
+type partitionedResponse [][][]complex128
+
+// blocks returns the number of blocks in each channel.
+func (r partitionedResponse) blocks() int {
+	if len(r) == 0 {
+		return 0
+	}
+	return len(r[0])
+}
+
+// newConvolver returns a convolver for the dry signal that crossfades over
+// the given number of samples when the impulse response changes.
+func newConvolver(dry signal, crossfade int) *convolver {
+	return &convolver{
+		dry:       dry,
+		crossfade: crossfade,
+		input:     make([]float64, 2*convolutionBlockSize),
+		spectrum:  make([]complex128, 2*convolutionBlockSize),
+		fadeOut:   make([]complex128, 2*convolutionBlockSize),
+		twiddles:  fftTwiddles(2 * convolutionBlockSize),
+		position:  convolutionBlockSize,
+	}
+}
+
+// setImpulseResponse hands the convolver a new binaural impulse response.
+func (c *convolver) setImpulseResponse(ir impulseResponse) {
+	c.setResponses([][]float64{ir.left, ir.right})
+}
+
+// setResponses hands the convolver a new impulse response per output channel,
+// which it switches to at the start of its next block. The input history is
+// kept, so the tail of earlier input carries on through the new response. It
+// is safe to call while another goroutine renders.
+func (c *convolver) setResponses(channels [][]float64) {
+	length := 0
+	for _, channel := range channels {
+		length = max(length, len(channel))
+	}
+	count := (length + convolutionBlockSize - 1) / convolutionBlockSize
+
+	partitions := make(partitionedResponse, (len(channels)+1)/2)
+	for pair := range partitions {
+		first := channels[2*pair]
+		var second []float64
+		if 2*pair+1 < len(channels) {
+			second = channels[2*pair+1]
+		}
+		partitions[pair] = make([][]complex128, count)
+		for p := range partitions[pair] {
+			spectrum := make([]complex128, 2*convolutionBlockSize)
+			for i := 0; i < convolutionBlockSize; i++ {
+				var a, b float64
+				if j := p*convolutionBlockSize + i; j < len(first) {
+					a = first[j]
+				}
+				if j := p*convolutionBlockSize + i; j < len(second) {
+					b = second[j]
+				}
+				spectrum[i] = complex(a, b)
+			}
+			fft(spectrum, c.twiddles, false)
+			partitions[pair][p] = spectrum
+		}
+	}
+
+	c.incoming.Store(&partitions)
+}
+
+// takeIncoming switches to a newly handed over impulse response, if there is
+// one.
+func (c *convolver) takeIncoming() {
+	incoming := c.incoming.Swap(nil)
+	if incoming == nil {
+		return
+	}
+	if c.previous != nil {
+		c.pending = *incoming
+	} else {
+		c.switchTo(*incoming)
+	}
+}
+
+// switchTo starts using the given partitions, fading from the current ones if
+// they have the same channels.
+func (c *convolver) switchTo(partitions partitionedResponse) {
+	if c.partitions != nil && c.crossfade > 0 && len(partitions) == len(c.partitions) {
+		c.previous, c.faded = c.partitions, 0
+	}
+	c.partitions = partitions
+	c.resizeHistory()
+	if len(c.output) != 2*len(partitions) {
+		output := make([][]float64, 2*len(partitions))
+		for ch := range output {
+			output[ch] = make([]float64, convolutionBlockSize)
+			if ch < len(c.output) {
+				copy(output[ch], c.output[ch])
+			}
+		}
+		c.output = output
+	}
+}
+
+// resizeHistory makes the delay line long enough for the partitions in use,
+// keeping the spectra of the most recent input blocks. The block p blocks old
+// stays p slots behind the head, which starts over at 0.
+func (c *convolver) resizeHistory() {
+	count := max(c.partitions.blocks(), c.previous.blocks())
+	if count == len(c.history) {
+		return
+	}
+	history := make([][]complex128, count)
+	for p := range history {
+		if p < len(c.history) {
+			history[(count-p)%count] = c.history[(c.head-p+len(c.history))%len(c.history)]
+		} else {
+			history[(count-p)%count] = make([]complex128, 2*convolutionBlockSize)
+		}
+	}
+	c.history, c.head = history, 0
+}
+
+// render fills each output channel with the next samples of the convolved
+// signal, such as left and right for a binaural response. Channels the
+// response doesn't have are silent.
+func (c *convolver) render(out ...[]float64) {
+	if len(out) == 0 {
+		return
+	}
+	for i := range out[0] {
+		if c.position == convolutionBlockSize {
+			c.processBlock()
+		}
+		for ch, channel := range out {
+			channel[i] = 0
+			if ch < len(c.output) {
+				channel[i] = c.output[ch][c.position]
+			}
+		}
+		c.position++
+	}
+}
+
+// processBlock reads a block of dry input and renders it into c.output
+// through the impulse response and the delay taps.
+func (c *convolver) processBlock() {
+	c.position = 0
+	c.takeIncoming()
+	copy(c.input, c.input[convolutionBlockSize:])
+	c.dry.read(c.input[convolutionBlockSize:])
+	c.convolveBlock()
+	c.renderTaps()
+}
+
+// convolveBlock convolves the newest block of input into c.output.
+func (c *convolver) convolveBlock() {
+	if len(c.history) == 0 {
+		for _, channel := range c.output {
+			clear(channel)
+		}
+		return
+	}
+
+	c.head = (c.head + 1) % len(c.history)
+	newest := c.history[c.head]
+	for i, x := range c.input {
+		newest[i] = complex(x, 0)
+	}
+	fft(newest, c.twiddles, false)
+
+	for pair, partitions := range c.partitions {
+		first, second := c.output[2*pair], c.output[2*pair+1]
+		c.convolve(partitions, c.spectrum)
+		if c.previous == nil {
+			for i := range first {
+				out := c.spectrum[convolutionBlockSize+i]
+				first[i], second[i] = real(out), imag(out)
+			}
+			continue
+		}
+
+		// Raised-cosine fade, whose gains add up to one at every sample
+		c.convolve(c.previous[pair], c.fadeOut)
+		for i := range first {
+			progress := math.Min(1, float64(c.faded+i)/float64(c.crossfade))
+			in := complex(0.5-0.5*math.Cos(math.Pi*progress), 0)
+			out := in*c.spectrum[convolutionBlockSize+i] + (1-in)*c.fadeOut[convolutionBlockSize+i]
+			first[i], second[i] = real(out), imag(out)
+		}
+	}
+	if c.previous == nil {
+		return
+	}
+
+	c.faded += convolutionBlockSize
+	if c.faded >= c.crossfade {
+		c.previous = nil
+		if c.pending != nil {
+			c.switchTo(c.pending)
+			c.pending = nil
+		} else {
+			c.resizeHistory()
+		}
+	}
+}
+
+// convolve multiplies the delay line with the partitions and transforms the
+// sum back into out. Overlap-save: the first half of out wraps around, the
+// second half is valid.
+func (c *convolver) convolve(partitions [][]complex128, out []complex128) {
+	clear(out)
+	for p, partition := range partitions {
+		past := c.history[(c.head-p+len(c.history))%len(c.history)]
+		for i := range out {
+			out[i] += past[i] * partition[i]
+		}
+	}
+	fft(out, c.twiddles, true)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+// sliceSignal plays back samples and then silence.
+type sliceSignal struct {
+	samples []float64
+}
+
+func (s *sliceSignal) read(out []float64) {
+	n := copy(out, s.samples)
+	clear(out[n:])
+	s.samples = s.samples[n:]
+}
+
+func TestFFTMatchesDFT(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	x := make([]complex128, 64)
+	for i := range x {
+		x[i] = complex(rng.NormFloat64(), rng.NormFloat64())
+	}
+
+	twiddles := fftTwiddles(len(x))
+	got := append([]complex128(nil), x...)
+	fft(got, twiddles, false)
+	for k := range x {
+		var want complex128
+		for n, v := range x {
+			want += v * cmplx.Rect(1, -2*math.Pi*float64(k*n)/float64(len(x)))
+		}
+		if cmplx.Abs(got[k]-want) > 1e-9 {
+			t.Fatalf("fft()[%d] = %v, want %v", k, got[k], want)
+		}
+	}
+
+	fft(got, twiddles, true)
+	for i := range x {
+		if cmplx.Abs(got[i]-x[i]) > 1e-12 {
+			t.Fatalf("inverse fft()[%d] = %v, want %v", i, got[i], x[i])
+		}
+	}
+}
+
+func TestConvolverMatchesDirectConvolution(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	random := func(n int) []float64 {
+		out := make([]float64, n)
+		for i := range out {
+			out[i] = rng.NormFloat64()
+		}
+		return out
+	}
+
+	dry := random(3000)
+	ir := impulseResponse{left: random(1300), right: random(700)}
+	c := newConvolver(&sliceSignal{append([]float64(nil), dry...)}, 0)
+	c.setImpulseResponse(ir)
+
+	// Render in odd-sized chunks so reads straddle block boundaries
+	n := len(dry) + len(ir.left)
+	left, right := make([]float64, n), make([]float64, n)
+	for start := 0; start < n; start += 333 {
+		end := min(n, start+333)
+		c.render(left[start:end], right[start:end])
+	}
+
+	for _, ear := range []struct {
+		name    string
+		got, ir []float64
+	}{
+		{"left", left, ir.left},
+		{"right", right, ir.right},
+	} {
+		for i := range ear.got {
+			want := 0.0
+			for j, h := range ear.ir {
+				if i-j >= 0 && i-j < len(dry) {
+					want += h * dry[i-j]
+				}
+			}
+			if math.Abs(ear.got[i]-want) > 1e-9 {
+				t.Fatalf("%s sample %d = %v, want %v", ear.name, i, ear.got[i], want)
+			}
+		}
+	}
+}
+
+func TestConvolverKeepsHistoryWhenResized(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	random := func(n int) []float64 {
+		out := make([]float64, n)
+		for i := range out {
+			out[i] = rng.NormFloat64()
+		}
+		return out
+	}
+
+	// The same response padded by three partitions grows the delay line when
+	// it arrives and shrinks it again when the fade back to the short one
+	// ends, neither of which may change the output
+	dry := random(12000)
+	ir := impulseResponse{left: random(1300), right: random(900)}
+	padded := impulseResponse{
+		left:  append(slices.Clone(ir.left), make([]float64, 3*convolutionBlockSize)...),
+		right: slices.Clone(ir.right),
+	}
+	c := newConvolver(&sliceSignal{slices.Clone(dry)}, 1000)
+	c.setImpulseResponse(ir)
+
+	left, right := make([]float64, len(dry)), make([]float64, len(dry))
+	for start, change := 0, 0; start < len(dry); start, change = start+333, change+1 {
+		switch change {
+		case 10:
+			c.setImpulseResponse(padded)
+		case 20:
+			c.setImpulseResponse(ir)
+		}
+		end := min(len(dry), start+333)
+		c.render(left[start:end], right[start:end])
+	}
+
+	for _, ear := range []struct {
+		name    string
+		got, ir []float64
+	}{
+		{"left", left, ir.left},
+		{"right", right, ir.right},
+	} {
+		for i := range ear.got {
+			want := 0.0
+			for j, h := range ear.ir {
+				if i-j >= 0 {
+					want += h * dry[i-j]
+				}
+			}
+			if math.Abs(ear.got[i]-want) > 1e-9 {
+				t.Fatalf("%s sample %d = %v, want %v", ear.name, i, ear.got[i], want)
+			}
+		}
+	}
+}
+
+// constantSignal is a steady DC level.
+type constantSignal float64
+
+func (s constantSignal) read(out []float64) {
+	for i := range out {
+		out[i] = float64(s)
+	}
+}
+
+func TestConvolverCrossfadesImpulseResponses(t *testing.T) {
+	const crossfade = 1500
+	gain := func(g float64) impulseResponse {
+		return impulseResponse{left: []float64{g}, right: []float64{-g}}
+	}
+	c := newConvolver(constantSignal(1), crossfade)
+	c.setImpulseResponse(gain(1))
+
+	left, right := make([]float64, 1000), make([]float64, 1000)
+	c.render(left, right)
+	if left[999] != 1 || right[999] != -1 {
+		t.Fatalf("before the change got %v, %v, want 1, -1", left[999], right[999])
+	}
+
+	// The change is picked up at the next block, and a second change during
+	// the fade waits for the first one to finish
+	c.setImpulseResponse(gain(3))
+	c.render(left[:100], right[:100])
+	c.setImpulseResponse(gain(2))
+
+	out := make([]float64, 5*crossfade)
+	c.render(out, make([]float64, len(out)))
+	previous := left[99]
+	for i, sample := range out {
+		if math.Abs(sample-previous) > 0.01 {
+			t.Fatalf("sample %d jumps from %v to %v", i, previous, sample)
+		}
+		previous = sample
+	}
+	if peak := slices.Max(out); peak < 2.99 {
+		t.Errorf("output peaked at %v, want it to reach 3 before fading to 2", peak)
+	}
+	if out[len(out)-1] != 2 {
+		t.Errorf("output settled at %v, want 2", out[len(out)-1])
+	}
+}
+
+func TestConvolverRendersWithoutAllocating(t *testing.T) {
+	c := newConvolver(constantSignal(1), 0)
+	response := make([]float64, 4*convolutionBlockSize)
+	response[100] = 1
+	c.setImpulseResponse(impulseResponse{left: response, right: response})
+	left, right := make([]float64, convolutionBlockSize), make([]float64, convolutionBlockSize)
+	c.render(left, right)
+
+	if allocs := testing.AllocsPerRun(20, func() { c.render(left, right) }); allocs != 0 {
+		t.Errorf("render() allocated %v times per block, want 0", allocs)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// fresnelIntegrals returns C(x) and S(x), the integrals from 0 to x of
+// cos(πt²/2) and sin(πt²/2). It uses the power series for small arguments and
+// a continued fraction for large ones.
+func fresnelIntegrals(x float64) (float64, float64) {
+	const (
+		eps     = 1e-15
+		maxIter = 200
+		floor   = 1e-300
+	)
+
+	ax := math.Abs(x)
+	var c, s float64
+	switch {
+	case ax < math.Sqrt(floor):
+		c, s = ax, 0
+	case ax <= 1.5:
+		sum, sumS, sumC := 0.0, 0.0, ax
+		sign, odd := 1.0, true
+		fact := math.Pi / 2 * ax * ax
+		term := ax
+		for k, n := 1, 3.0; k <= maxIter; k, n = k+1, n+2 {
+			term *= fact / float64(k)
+			sum += sign * term / n
+			test := math.Abs(sum) * eps
+			if odd {
+				sign = -sign
+				sumS = sum
+				sum = sumC
+			} else {
+				sumC = sum
+				sum = sumS
+			}
+			if term < test {
+				break
+			}
+			odd = !odd
+		}
+		c, s = sumC, sumS
+	default:
+		pix2 := math.Pi * ax * ax
+		b := complex(1, -pix2)
+		cc := complex(1/floor, 0)
+		d := 1 / b
+		h := d
+		n := -1.0
+		for k := 2; k <= maxIter; k++ {
+			n += 2
+			a := complex(-n*(n+1), 0)
+			b += 4
+			d = 1 / (a*d + b)
+			cc = b + a/cc
+			del := cc * d
+			h *= del
+			if math.Abs(real(del)-1)+math.Abs(imag(del)) < eps {
+				break
+			}
+		}
+		h *= complex(ax, -ax)
+		cs := complex(0.5, 0.5) * (1 - complex(math.Cos(pix2/2), math.Sin(pix2/2))*h)
+		c, s = real(cs), imag(cs)
+	}
+
+	if x < 0 {
+		return -c, -s
+	}
+	return c, s
+}
+
+// transitionFunction is the UTD transition function
+// F(X) = 2j√X e^{jX} ∫_{√X}^∞ e^{-jτ²} dτ, which goes to 0 at the shadow and
+// reflection boundaries and to 1 far away from them.
+func transitionFunction(x float64) complex128 {
+	if x <= 0 {
+		return 0
+	}
+	sqrtX := math.Sqrt(x)
+	c, s := fresnelIntegrals(sqrtX * math.Sqrt(2/math.Pi))
+	tail := complex(math.Sqrt(math.Pi/2)*(0.5-c), -math.Sqrt(math.Pi/2)*(0.5-s))
+	return 2i * complex(sqrtX, 0) * cmplx.Exp(complex(0, x)) * tail
+}
+
+// utdCoefficient returns the Kouyoumjian–Pathak diffraction coefficient of an
+// acoustically rigid wedge whose open region spans n·π radians, for a source
+// at angle phiS and a receiver at angle phiR measured from face 0, wavenumber
+// k and distance parameter l = s·s'/(s+s').
+func utdCoefficient(n, phiS, phiR, k, l float64) complex128 {
+	// At a shadow or reflection boundary one cotangent diverges while its
+	// transition function vanishes. The product has a finite limit, which a
+	// tiny nudge of the receiver angle reaches without special-casing it.
+	for _, beta := range []float64{phiR - phiS, phiR + phiS} {
+		for _, sign := range []float64{1, -1} {
+			if math.Abs(math.Sin((math.Pi+sign*beta)/(2*n))) < 1e-9 {
+				phiR += 1e-7
+			}
+		}
+	}
+
+	term := func(sign, beta float64) complex128 {
+		N := math.Round((beta + sign*math.Pi) / (2 * math.Pi * n))
+		a := 2 * math.Pow(math.Cos((2*n*math.Pi*N-beta)/2), 2)
+		cot := 1 / math.Tan((math.Pi+sign*beta)/(2*n))
+		return complex(cot, 0) * transitionFunction(k*l*a)
+	}
+
+	betaMinus, betaPlus := phiR-phiS, phiR+phiS
+	sum := term(1, betaMinus) + term(-1, betaMinus) + term(1, betaPlus) + term(-1, betaPlus)
+	return -cmplx.Exp(complex(0, -math.Pi/4)) / complex(2*n*math.Sqrt(2*math.Pi*k), 0) * sum
+}
+
+// wedge describes the open region around a diffracting edge. It starts at
+// face0 and sweeps n·π radians in the rotational sense given by sense.
+type wedge struct {
+	face0 Vector
+	sense float64
+	n     float64
+}
+
+// alongWallFrom returns the unit direction from the given endpoint of the wall
+// towards its other end.
+func alongWallFrom(wall Wall, endpoint Vector) Vector {
+	if wall.start == endpoint {
+		return Vector{wall.end.x - wall.start.x, wall.end.y - wall.start.y}.normalize()
+	}
+	return Vector{wall.start.x - wall.end.x, wall.start.y - wall.end.y}.normalize()
+}
+
+// edgeWedge derives the wedge of an edge from the normals of its faces. A free
+// wall end is a thin half-plane whose faces are back to back, so n = 2. At a
+// corner the normals are first turned away from the opposite face, after which
+// the angle between them is π minus the solid angle of the wedge.
+func (g *Game) edgeWedge(edge WallEdge) wedge {
+	face0 := alongWallFrom(g.walls[edge.wall1], edge.position)
+	normal0 := edge.normal1
+	normalN := Vector{-edge.normal1.x, -edge.normal1.y}
+	if edge.isCorner {
+		faceN := alongWallFrom(g.walls[edge.wall2], edge.position)
+		normal0 = faceAgainst(edge.normal1, faceN)
+		normalN = faceAgainst(edge.normal2, face0)
+	}
+
+	between := math.Acos(math.Max(-1, math.Min(1, dot(normal0, normalN))))
+	sense := 1.0
+	if face0.x*normal0.y-face0.y*normal0.x < 0 {
+		sense = -1.0
+	}
+	return wedge{face0: face0, sense: sense, n: 1 + between/math.Pi}
+}
+
+// angle returns the angle of the direction v measured from face 0 through the
+// open region, in [0, 2π). Angles above n·π lie inside the wedge.
+func (w wedge) angle(v Vector) float64 {
+	a := math.Atan2(w.sense*(w.face0.x*v.y-w.face0.y*v.x), dot(w.face0, v))
+	if a < 0 {
+		a += 2 * math.Pi
+	}
+	return a
+}
+
+// diffractedEnergy returns the band energy of sound diffracted by the edge
+// from source to receiver relative to the free-field energy over the same
+// path length, or false if either point lies inside the wedge.
+func (g *Game) diffractedEnergy(edge WallEdge, source, receiver Vector) (bands, bool) {
+	var energy bands
+	w := g.edgeWedge(edge)
+
+	toSource := Vector{source.x - edge.position.x, source.y - edge.position.y}
+	toReceiver := Vector{receiver.x - edge.position.x, receiver.y - edge.position.y}
+	sPrime, s := toSource.length(), toReceiver.length()
+	if sPrime < 1e-9 || s < 1e-9 {
+		return energy, false
+	}
+
+	phiS, phiR := w.angle(toSource), w.angle(toReceiver)
+	if phiS > w.n*math.Pi || phiR > w.n*math.Pi {
+		return energy, false
+	}
+
+	// For a point source the diffracted pressure is D·√(s'/(s(s+s'))) times
+	// the incident pressure at the edge, so relative to free field over s+s'
+	// the energy is |D|²·(s+s')/(s·s')
+	l := s * sPrime / (s + sPrime)
+	for i, frequency := range bandFrequencies {
+		k := 2 * math.Pi * frequency / g.atmosphere.speedOfSound()
+		d := cmplx.Abs(utdCoefficient(w.n, phiS, phiR, k, l))
+		energy[i] = d * d * (s + sPrime) / (s * sPrime)
+	}
+	return energy, true
+}
+
+// addDiffractionPaths adds an AudioPath per ear for the sound from the given
+// source that reaches the listener by diffraction around each edge of the
+// scene, and records those paths for drawing.
+func (g *Game) addDiffractionPaths(index int) {
+	source, receiver := g.audioSources[index].position, g.listener.position
+	speedOfSound := g.atmosphere.speedOfSound()
+
+	for _, edge := range g.wallEdges {
+		energy, ok := g.diffractedEnergy(edge, source, receiver)
+		if !ok {
+			continue
+		}
+
+		visibility := g.transmissionAlong(source, edge.position, edge.wall1, edge.wall2).
+			mul(g.transmissionAlong(edge.position, receiver, edge.wall1, edge.wall2))
+		energy = energy.mul(visibility)
+		drawnIntensity := math.Min(1, energy.mean())
+
+		sourceToEdge := distance(source, edge.position)
+		pathLength := sourceToEdge + distance(edge.position, receiver)
+		energy = energy.scale(spreadingLoss(pathLength)).mul(g.airAttenuation(pathLength))
+		if energy.max() < 1e-6 {
+			continue
+		}
+
+		g.rayPathPoints = append(g.rayPathPoints, []RayPathPoint{
+			{source, drawnIntensity},
+			{edge.position, drawnIntensity},
+			{receiver, drawnIntensity},
+		})
+
+		direction := Vector{edge.position.x - receiver.x, edge.position.y - receiver.y}.normalize()
+		g.leftPaths = append(g.leftPaths, AudioPath{
+			source:    index,
+			delay:     (sourceToEdge + distance(edge.position, g.listener.leftEar)) / speedOfSound,
+			energy:    energy,
+			direction: direction,
+		})
+		g.rightPaths = append(g.rightPaths, AudioPath{
+			source:    index,
+			delay:     (sourceToEdge + distance(edge.position, g.listener.rightEar)) / speedOfSound,
+			energy:    energy,
+			direction: direction,
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+)
+
+func TestFresnelIntegrals(t *testing.T) {
+	tests := []struct {
+		x, wantC, wantS float64
+	}{
+		{0, 0, 0},
+		{0.5, 0.4923442, 0.0647324},
+		{1, 0.7798934, 0.4382591},
+		{2, 0.4882534, 0.3434157},
+		{5, 0.5636312, 0.4991914},
+		{-1, -0.7798934, -0.4382591},
+	}
+
+	for _, tt := range tests {
+		c, s := fresnelIntegrals(tt.x)
+		if math.Abs(c-tt.wantC) > 1e-6 || math.Abs(s-tt.wantS) > 1e-6 {
+			t.Errorf("fresnelIntegrals(%v) = (%v, %v), want (%v, %v)", tt.x, c, s, tt.wantC, tt.wantS)
+		}
+	}
+}
+
+func TestTransitionFunctionLimits(t *testing.T) {
+	if got := transitionFunction(0); got != 0 {
+		t.Errorf("transitionFunction(0) = %v, want 0", got)
+	}
+	if got := transitionFunction(1000); cmplx.Abs(got-1) > 1e-3 {
+		t.Errorf("transitionFunction(1000) = %v, want about 1", got)
+	}
+}
+
+func TestHalfPlaneDiffraction(t *testing.T) {
+	// A thin wall along the positive x axis, with its free end at the origin
+	g := &Game{
+		walls:      []Wall{{Vector{0, 0}, Vector{1000, 0}, WallProperties{}}},
+		atmosphere: standardAtmosphere(),
+	}
+	g.getWallEdges()
+	edge := g.wallEdges[0]
+	if edge.position != (Vector{0, 0}) || edge.isCorner {
+		t.Fatalf("unexpected first edge %+v", edge)
+	}
+	if n := g.edgeWedge(edge).n; math.Abs(n-2) > 1e-12 {
+		t.Fatalf("free wall end has n = %v, want 2", n)
+	}
+
+	source := Vector{500, -500}
+
+	// On the shadow boundary the diffracted field is half the incident one
+	boundary := Vector{-500, 500}
+	energy, ok := g.diffractedEnergy(edge, source, boundary)
+	if !ok {
+		t.Fatalf("diffractedEnergy() on the shadow boundary returned not ok")
+	}
+	if got := energy[numBands-1]; math.Abs(got-0.25) > 0.02 {
+		t.Errorf("energy on the shadow boundary = %v, want about 0.25", got)
+	}
+
+	// Deep in the shadow low frequencies bend around the edge more easily
+	shadow := Vector{300, 500}
+	energy, _ = g.diffractedEnergy(edge, source, shadow)
+	for i := 1; i < numBands; i++ {
+		if energy[i] >= energy[i-1] {
+			t.Errorf("shadow energy at %v Hz = %v, not below %v at %v Hz", bandFrequencies[i], energy[i], energy[i-1], bandFrequencies[i-1])
+		}
+	}
+}
+
+func TestCornerWedgeIndex(t *testing.T) {
+	g := &Game{walls: []Wall{
+		{Vector{0, 0}, Vector{100, 0}, WallProperties{}},
+		{Vector{100, 0}, Vector{100, 100}, WallProperties{}},
+	}}
+	g.getWallEdges()
+
+	for _, edge := range g.wallEdges {
+		if !edge.isCorner {
+			continue
+		}
+		if edge.wall1 == edge.wall2 {
+			t.Fatalf("corner %v has the same wall on both faces", edge.position)
+		}
+		if n := g.edgeWedge(edge).n; math.Abs(n-1.5) > 1e-12 {
+			t.Errorf("right-angle corner has n = %v, want 1.5", n)
+		}
+		return
+	}
+	t.Fatalf("no corner found in %+v", g.wallEdges)
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+	"sort"
+	"sync/atomic"
+)
+
+const (
+	// Number of moving image-source paths per source, the strongest ones, that
+	// are rendered through moving delay taps when Doppler shift is on.
+	maxDopplerPaths = 8
+	// Largest correction of a tap's delay, in samples per sample of the glide,
+	// that it glides over. Larger ones, such as a listener jumping to where
+	// the mouse was clicked, would bend the pitch, so the tap fades out and a
+	// new one fades in instead.
+	maxDopplerGlide = 0.02
+)
+
+// dopplerTap is one path on one output channel, rendered by reading the dry
+// input through a delay that keeps changing at rate. A delay that shrinks
+// plays the input faster and raises its pitch, which is the Doppler shift of
+// a source and listener closing in on each other.
+type dopplerTap struct {
+	key     int // the path's imageKey, which with channel identifies the tap across updates
+	channel int
+	delay   float64 // in samples, when the tap is handed over
+	rate    float64 // change of delay per sample
+	gains   bands   // the path's pressure per band times its ear or panning gain
+}
+
+// dopplerLine renders a convolver's delay taps from a history of its dry
+// input long enough for the longest delay. When new taps are handed over,
+// taps that carry on glide to their new delay over the crossfade instead of
+// jumping to it, and fade to their new gains; taps that start or end fade in
+// or out.
+type dopplerLine struct {
+	incoming atomic.Pointer[[]dopplerTap]
+	taps     []tapState
+	input    []float64 // ring of past dry input, nil until the first taps
+	written  int       // samples of dry input seen
+}
+
+// tapState is a tap being rendered. Its band gains are applied with a low
+// shelf at every crossover between bands, which lifts everything below it
+// roughly by the ratio of the gains either side, and an overall gain.
+type tapState struct {
+	dopplerTap
+	previous  bands // gains being faded out while fading
+	fading    bool
+	faded     int     // samples of the gain fade rendered so far
+	level     float64 // envelope position from 0 (silent) to 1 (playing)
+	ending    bool    // fading out, to be dropped when silent
+	glide     float64 // extra change of delay per sample while correcting it
+	glideLeft int     // samples left to glide
+	shelves   [numBands - 1]biquad
+	top       float64 // gain after the shelves
+	shaped    bands   // the gains the shelves and top are set for
+}
+
+func newTapState(tap dopplerTap) tapState {
+	state := tapState{dopplerTap: tap}
+	state.shape(tap.gains)
+	return state
+}
+
+// shape sets the shelves and top gain for the given gains, keeping the
+// shelves' state. Gains more than 80 dB under the loudest band are raised to
+// that.
+func (t *tapState) shape(gains bands) {
+	t.shaped = gains
+	floor := 1e-4 * gains.max()
+	if floor == 0 {
+		t.top = 0
+		return
+	}
+	for k := range t.shelves {
+		ratio := math.Max(floor, gains[k]) / math.Max(floor, gains[k+1])
+		shelf := newLowShelf(bandFrequencies[k]*math.Sqrt2, ratio)
+		shelf.z1, shelf.z2 = t.shelves[k].z1, t.shelves[k].z2
+		t.shelves[k] = *shelf
+	}
+	t.top = math.Max(floor, gains[numBands-1])
+}
+
+// gainsAt returns the gains applied i samples into the current block.
+func (t *tapState) gainsAt(i int, step float64) bands {
+	if !t.fading {
+		return t.gains
+	}
+	in := raisedCosine(math.Min(1, float64(t.faded+i)*step))
+	gains := t.gains.scale(in)
+	for b, gain := range t.previous {
+		gains[b] += (1 - in) * gain
+	}
+	return gains
+}
+
+// filter runs x through the shelves.
+func (t *tapState) filter(x float64) float64 {
+	for k := range t.shelves {
+		x = t.shelves[k].process(x)
+	}
+	return x
+}
+
+// newLowShelf returns a low shelf from the Audio EQ Cookbook with a slope of
+// 1, which multiplies frequencies well below cutoff by gain and leaves those
+// well above it alone.
+func newLowShelf(cutoff, gain float64) *biquad {
+	a := math.Sqrt(gain)
+	w0 := 2 * math.Pi * cutoff / sampleRate
+	alpha := math.Sin(w0) / math.Sqrt2
+	cosW0 := math.Cos(w0)
+	root := 2 * math.Sqrt(a) * alpha
+	a0 := (a + 1) + (a-1)*cosW0 + root
+	return &biquad{
+		b0: a * ((a + 1) - (a-1)*cosW0 + root) / a0,
+		b1: 2 * a * ((a - 1) - (a+1)*cosW0) / a0,
+		b2: a * ((a + 1) - (a-1)*cosW0 - root) / a0,
+		a1: -2 * ((a - 1) + (a+1)*cosW0) / a0,
+		a2: ((a + 1) + (a-1)*cosW0 - root) / a0,
+	}
+}
+
+// setTaps hands the convolver a new set of delay taps, which it switches to
+// at the start of its next block. It is safe to call while another goroutine
+// renders.
+func (c *convolver) setTaps(taps []dopplerTap) {
+	c.doppler.incoming.Store(&taps)
+}
+
+// takeTaps matches newly handed over taps with the ones playing. Taps that
+// are ending are left to fade out, and a path that comes back gets a new tap.
+func (c *convolver) takeTaps(taps []dopplerTap) {
+	d := &c.doppler
+	glide := max(c.crossfade, convolutionBlockSize)
+	step := 1 / float64(max(1, c.crossfade))
+	type id struct{ key, channel int }
+	playing := make(map[id]int, len(d.taps))
+	for i, tap := range d.taps {
+		if !tap.ending {
+			playing[id{tap.key, tap.channel}] = i
+		}
+	}
+
+	next := make([]tapState, 0, len(taps)+len(d.taps))
+	carried := make([]bool, len(d.taps))
+	for _, tap := range taps {
+		i, ok := playing[id{tap.key, tap.channel}]
+		if !ok || math.Abs(tap.delay-d.taps[i].delay) > maxDopplerGlide*float64(glide) {
+			next = append(next, newTapState(tap))
+			continue
+		}
+		carried[i] = true
+
+		state := d.taps[i]
+		state.rate = tap.rate
+		state.glide, state.glideLeft = (tap.delay-state.delay)/float64(glide), glide
+		if state.gains != tap.gains {
+			state.previous, state.fading, state.faded = state.gainsAt(0, step), true, 0
+			state.gains = tap.gains
+		}
+		next = append(next, state)
+	}
+	for i, state := range d.taps {
+		if !carried[i] {
+			state.ending = true
+			next = append(next, state)
+		}
+	}
+	d.taps = next
+}
+
+// renderTaps adds the output of the delay taps for the newest block of input
+// to c.output.
+func (c *convolver) renderTaps() {
+	d := &c.doppler
+	if incoming := d.incoming.Swap(nil); incoming != nil {
+		c.takeTaps(*incoming)
+	}
+	if d.input == nil {
+		if len(d.taps) == 0 {
+			return
+		}
+		size := 1
+		for size < int(maxImpulseResponseSeconds*sampleRate)+2*convolutionBlockSize {
+			size <<= 1
+		}
+		d.input = make([]float64, size)
+	}
+
+	start := d.written
+	for i, x := range c.input[convolutionBlockSize:] {
+		d.input[(start+i)&(len(d.input)-1)] = x
+	}
+	d.written += convolutionBlockSize
+
+	step := 1 / float64(max(1, c.crossfade))
+	maxDelay := float64(len(d.input) - convolutionBlockSize - 4)
+	kept := d.taps[:0]
+	for i := range d.taps {
+		tap := &d.taps[i]
+		var out []float64
+		if tap.channel < len(c.output) {
+			out = c.output[tap.channel]
+		}
+		// While fading, the shelves follow the gains a block at a time
+		if gains := tap.gainsAt(convolutionBlockSize/2, step); gains != tap.shaped {
+			tap.shape(gains)
+		}
+		for i := 0; i < convolutionBlockSize; i++ {
+			tap.delay = math.Max(2, math.Min(maxDelay, tap.delay))
+			x := d.at(float64(start+i) - tap.delay)
+			tap.delay += tap.rate
+			if tap.glideLeft > 0 {
+				tap.delay += tap.glide
+				tap.glideLeft--
+			}
+			if tap.ending {
+				tap.level = math.Max(0, tap.level-step)
+			} else {
+				tap.level = math.Min(1, tap.level+step)
+			}
+			if out != nil {
+				out[i] += raisedCosine(tap.level) * tap.top * tap.filter(x)
+			}
+		}
+		if tap.fading {
+			if tap.faded += convolutionBlockSize; tap.faded >= c.crossfade {
+				tap.fading = false
+			}
+		}
+
+		if !tap.ending || tap.level > 0 {
+			kept = append(kept, *tap)
+		}
+	}
+	d.taps = kept
+}
+
+// at returns the dry input at a fractional sample time by cubic Lagrange
+// interpolation between the four samples around it.
+func (d *dopplerLine) at(t float64) float64 {
+	i := int(math.Floor(t))
+	f := t - float64(i)
+	sample := func(j int) float64 {
+		if j < 0 {
+			return 0
+		}
+		return d.input[j&(len(d.input)-1)]
+	}
+	return -f*(f-1)*(f-2)/6*sample(i-1) +
+		(f+1)*(f-1)*(f-2)/2*sample(i) -
+		(f+1)*f*(f-2)/2*sample(i+1) +
+		(f+1)*f*(f-1)/6*sample(i+2)
+}
+
+// raisedCosine maps a fade's progress from 0 to 1 onto a gain that starts
+// and ends smoothly.
+func raisedCosine(progress float64) float64 {
+	if progress >= 1 {
+		return 1 // a tap that isn't fading, without a cosine per sample
+	}
+	return 0.5 - 0.5*math.Cos(math.Pi*progress)
+}
+
+// level returns the broadband amplitude of the path, which keeps the energy
+// of its bands.
+func (p AudioPath) level() float64 {
+	return math.Sqrt(p.energy.mean())
+}
+
+// imageVelocity returns how fast image i moves while the real source moves at
+// velocity: each mirroring reflects the velocity across the wall too.
+func (g *Game) imageVelocity(images []imageSource, i int, velocity Vector) Vector {
+	if images[i].parent == -1 {
+		return velocity
+	}
+	velocity = g.imageVelocity(images, images[i].parent, velocity)
+	wall := g.walls[images[i].wall]
+	wallDir := Vector{wall.end.x - wall.start.x, wall.end.y - wall.start.y}.normalize()
+	return reflect(velocity, Vector{-wallDir.y, wallDir.x})
+}
+
+// delayRate returns how fast the delay from a source to a receiver changes as
+// they move, in seconds per second.
+func delayRate(source, sourceVelocity, receiver, receiverVelocity Vector, speedOfSound float64) float64 {
+	towards := Vector{source.x - receiver.x, source.y - receiver.y}.normalize()
+	relative := Vector{sourceVelocity.x - receiverVelocity.x, sourceVelocity.y - receiverVelocity.y}
+	// Near the speed of sound the delay would stop or run backwards
+	return math.Max(-0.9, math.Min(0.9, dot(towards, relative)/speedOfSound))
+}
+
+// markDopplerPaths flags the strongest image-source paths from the given
+// source whose delay is changing, at most maxDopplerPaths, to be rendered
+// through moving delay taps instead of the impulse response. Paths that hold
+// still stay in the impulse response with the rest. Only image-source paths
+// keep their identity from one update to the next for a tap to follow, so the
+// ray-tracing engine gets no Doppler shift.
+func (g *Game) markDopplerPaths(source int) {
+	moving := make(map[int]bool)
+	for _, path := range g.rightPaths {
+		if path.source == source && path.imageKey != 0 && path.delayRate != 0 {
+			moving[path.imageKey] = true
+		}
+	}
+	var candidates []AudioPath
+	for _, path := range g.leftPaths {
+		if path.source == source && path.imageKey != 0 && (path.delayRate != 0 || moving[path.imageKey]) {
+			candidates = append(candidates, path)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].level() > candidates[j].level() })
+
+	chosen := make(map[int]bool)
+	for _, path := range candidates[:min(len(candidates), maxDopplerPaths)] {
+		chosen[path.imageKey] = true
+	}
+	for _, paths := range [][]AudioPath{g.leftPaths, g.rightPaths} {
+		for i, path := range paths {
+			if path.source == source && chosen[path.imageKey] {
+				paths[i].doppler = true
+			}
+		}
+	}
+}
+
+// dopplerPathsFrom returns the paths that start at the given source and are
+// rendered through delay taps.
+func dopplerPathsFrom(paths []AudioPath, source int) []AudioPath {
+	var from []AudioPath
+	for _, path := range paths {
+		if path.source == source && path.doppler {
+			from = append(from, path)
+		}
+	}
+	return from
+}
+
+// buildDopplerTaps turns the paths from the given source flagged by
+// markDopplerPaths into delay taps, delayed and weighted per band for each
+// output channel the way buildResponses places paths in the impulse response.
+// Instead of the full HRIR, an HRTF adds its gain per band and the time it
+// takes to reach its peak.
+func (g *Game) buildDopplerTaps(source int) []dopplerTap {
+	left := dopplerPathsFrom(g.leftPaths, source)
+	right := dopplerPathsFrom(g.rightPaths, source)
+	var taps []dopplerTap
+
+	if p := g.panner(); p != nil {
+		// Both ears hear the same image sources in the same order, and the
+		// taps take the average of the two at the centre of the head
+		left, right = g.atHeadCentre(left, g.listener.leftEar), g.atHeadCentre(right, g.listener.rightEar)
+		for i, path := range left {
+			delay := (path.delay + right[i].delay) / 2
+			rate := (path.delayRate + right[i].delayRate) / 2
+			for ch, gain := range p.pan(azimuthOf(g.listener.relative(path.direction))) {
+				if gain != 0 {
+					taps = append(taps, dopplerTap{key: path.imageKey, channel: ch, delay: delay * sampleRate, rate: rate, gains: path.energy.sqrt().scale(gain)})
+				}
+			}
+		}
+		return taps
+	}
+
+	for ch, ear := range []struct {
+		paths    []AudioPath
+		position Vector
+	}{{left, g.listener.leftEar}, {right, g.listener.rightEar}} {
+		isLeft := ch == 0
+		response := g.earResponse(isLeft)
+		for _, path := range g.referToHeadCentre(ear.paths, ear.position, isLeft) {
+			gains, onset := earBands(response(path.direction))
+			taps = append(taps, dopplerTap{key: path.imageKey, channel: ch, delay: path.delay*sampleRate + onset, rate: path.delayRate, gains: path.energy.sqrt().mul(gains)})
+		}
+	}
+	return taps
+}
+
+// earBands reduces an ear's response to a direction to its gain at the centre
+// of each band and the position of its peak in samples.
+func earBands(response []float64) (bands, float64) {
+	var gains bands
+	for b, frequency := range bandFrequencies {
+		var sum complex128
+		for n, h := range response {
+			sum += complex(h, 0) * cmplx.Rect(1, -2*math.Pi*frequency*float64(n)/sampleRate)
+		}
+		gains[b] = cmplx.Abs(sum)
+	}
+	peak := 0
+	for n, h := range response {
+		if math.Abs(h) > math.Abs(response[peak]) {
+			peak = n
+		}
+	}
+	return gains, float64(peak)
+}
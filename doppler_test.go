@@ -0,0 +1,236 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// tapConvolver returns a convolver with two silent output channels through
+// which only the given taps sound.
+func tapConvolver(dry signal, crossfade int, taps ...dopplerTap) *convolver {
+	c := newConvolver(dry, crossfade)
+	c.setResponses([][]float64{{0}, {0}})
+	c.setTaps(taps)
+	return c
+}
+
+// zeroCrossingFrequency measures the frequency of a sine from the time
+// between its first and last rising zero crossings.
+func zeroCrossingFrequency(samples []float64) float64 {
+	first, last, cycles := -1.0, -1.0, 0
+	for i := 1; i < len(samples); i++ {
+		if samples[i-1] < 0 && samples[i] >= 0 {
+			at := float64(i-1) + samples[i-1]/(samples[i-1]-samples[i])
+			if first < 0 {
+				first = at
+			} else {
+				cycles++
+			}
+			last = at
+		}
+	}
+	return float64(cycles) * sampleRate / (last - first)
+}
+
+func TestDopplerTapShiftsPitch(t *testing.T) {
+	const frequency = 1000.0
+	tests := []struct {
+		name  string
+		delay float64 // samples
+		rate  float64
+	}{
+		{"approaching", 30000, -0.05},
+		{"receding", 1000, 0.05},
+		{"still", 1000, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := tapConvolver(&sineSignal{frequency: frequency, amplitude: 1}, 0,
+				dopplerTap{channel: 1, delay: test.delay, rate: test.rate, gains: uniformBands(1)})
+			left, right := make([]float64, sampleRate), make([]float64, sampleRate)
+			c.render(left, right)
+
+			if left[len(left)-1] != 0 {
+				t.Errorf("the tap leaked into the other channel")
+			}
+			got := zeroCrossingFrequency(right[sampleRate/2:])
+			want := frequency * (1 - test.rate)
+			if math.Abs(got-want) > 0.5 {
+				t.Errorf("heard %.2f Hz, want %.2f Hz", got, want)
+			}
+		})
+	}
+}
+
+// rampSignal counts up by one every sample.
+type rampSignal struct {
+	next float64
+}
+
+func (s *rampSignal) read(out []float64) {
+	for i := range out {
+		out[i] = s.next
+		s.next++
+	}
+}
+
+func TestDopplerTapGlidesToNewDelay(t *testing.T) {
+	const crossfade = 1024
+	// Through a tap the ramp reads n - delay, which the cubic interpolation
+	// keeps exact at fractional delays too
+	c := tapConvolver(&rampSignal{}, crossfade, dopplerTap{channel: 0, delay: 100, gains: uniformBands(1)})
+	out := make([]float64, 4*crossfade)
+	c.render(out, make([]float64, len(out)))
+	if want := float64(len(out) - 1 - 100); math.Abs(out[len(out)-1]-want) > 1e-9 {
+		t.Fatalf("before the change got %v, want %v", out[len(out)-1], want)
+	}
+
+	c.setTaps([]dopplerTap{{channel: 0, delay: 115, gains: uniformBands(1)}})
+	previous := out[len(out)-1]
+	c.render(out, make([]float64, len(out)))
+	for i, sample := range out {
+		// The delay grows by 15 samples over the glide, so the ramp slows
+		// down a little but never stops or jumps
+		if step := sample - previous; step < 1-2*15.0/crossfade || step > 1+1e-9 {
+			t.Fatalf("sample %d steps by %v", i, step)
+		}
+		previous = sample
+	}
+	if want := float64(8*crossfade - 1 - 115); math.Abs(out[len(out)-1]-want) > 1e-6 {
+		t.Errorf("after the glide got %v, want %v", out[len(out)-1], want)
+	}
+}
+
+func TestDopplerTapFadesAcrossJumps(t *testing.T) {
+	const crossfade = 1024
+	c := tapConvolver(&rampSignal{}, crossfade, dopplerTap{channel: 0, delay: 100, gains: uniformBands(1)})
+	out := make([]float64, 4*crossfade)
+	c.render(out, make([]float64, len(out)))
+
+	// Too far to glide without bending the pitch, so the ramp is faded from
+	// the old delay to the new one and never read in between
+	c.setTaps([]dopplerTap{{channel: 0, delay: 600, gains: uniformBands(1)}})
+	c.render(out, make([]float64, len(out)))
+	for i, sample := range out {
+		n := float64(len(out) + i)
+		if sample < n-600-1e-9 || sample > n-100+1e-9 {
+			t.Fatalf("sample %d = %v, between delays of 100 and 600 samples", i, sample)
+		}
+	}
+	if want := float64(8*crossfade - 1 - 600); math.Abs(out[len(out)-1]-want) > 1e-9 {
+		t.Errorf("after the fade got %v, want %v", out[len(out)-1], want)
+	}
+	if len(c.doppler.taps) != 1 {
+		t.Errorf("%d taps playing after the fade, want 1", len(c.doppler.taps))
+	}
+}
+
+func TestDopplerTapAppliesBandGains(t *testing.T) {
+	// A tap keeps the amplitude of each band of a reflection off a curtain
+	// roughly, as the impulse response would
+	gains := curtainPartition.absorption.complement().sqrt()
+	for b, frequency := range bandFrequencies {
+		c := tapConvolver(&sineSignal{frequency: frequency, amplitude: 1}, 0, dopplerTap{channel: 0, delay: 100, gains: gains})
+		out := make([]float64, sampleRate)
+		c.render(out, make([]float64, len(out)))
+		got := correlate(out[sampleRate/2:], frequency, sampleRate)
+		if math.Abs(decibels(got/gains[b])) > 1 {
+			t.Errorf("%v Hz comes through at %.3f, want about %v", frequency, got, gains[b])
+		}
+	}
+}
+
+func TestOnlyMovingPathsGetDopplerTaps(t *testing.T) {
+	g := rectangularRoom(10, 6, WallProperties{})
+	g.audioSources = []AudioSource{{position: Vector{3, 2}, enabled: true}}
+	g.moveListener(Vector{7, 4})
+	g.imageSourceOrder = 1
+	g.addImageSourcePaths(0)
+
+	g.markDopplerPaths(0)
+	if taps := g.buildDopplerTaps(0); len(taps) != 0 {
+		t.Errorf("%d taps with nothing moving, want none", len(taps))
+	}
+
+	g.leftPaths, g.rightPaths = nil, nil
+	g.audioSources[0].velocity = Vector{10, 0}
+	g.addImageSourcePaths(0)
+	g.markDopplerPaths(0)
+	if taps := g.buildDopplerTaps(0); len(taps) != 2*len(g.leftPaths) {
+		t.Errorf("%d taps with the source moving, want %d", len(taps), 2*len(g.leftPaths))
+	}
+}
+
+func TestImageVelocityIsMirrored(t *testing.T) {
+	g := rectangularRoom(10, 6, WallProperties{})
+	images := g.buildImageSources(Vector{3, 2}, 2)
+	velocity := Vector{1, 2}
+
+	want := map[[2]int]Vector{
+		{0, -1}: {1, -2},  // ceiling
+		{1, -1}: {-1, 2},  // right wall
+		{1, 0}:  {-1, -2}, // ceiling, then right wall
+	}
+	found := 0
+	for i, image := range images {
+		parentWall := -1
+		if image.parent > 0 {
+			parentWall = images[image.parent].wall
+		}
+		w, ok := want[[2]int{image.wall, parentWall}]
+		if !ok {
+			continue
+		}
+		found++
+		if got := g.imageVelocity(images, i, velocity); math.Abs(got.x-w.x) > 1e-12 || math.Abs(got.y-w.y) > 1e-12 {
+			t.Errorf("image across walls %d after %d moves at %v, want %v", image.wall, parentWall, got, w)
+		}
+	}
+	if found != len(want) {
+		t.Fatalf("found %d of the %d images", found, len(want))
+	}
+
+	// The image above the ceiling moves down, away from a listener in the
+	// room, so its delay grows
+	image := mirrorAcrossWall(Vector{3, 2}, g.walls[0])
+	if rate := delayRate(image, Vector{1, -2}, Vector{3, 4}, Vector{}, 343); rate <= 0 {
+		t.Errorf("delay rate %v, want it to grow", rate)
+	}
+}
+
+func TestDopplerFollowsImageSourcePathsOnly(t *testing.T) {
+	tests := []struct {
+		engine      propagationEngine
+		wantDoppler bool
+	}{
+		{engine: engineHybrid, wantDoppler: true},
+		{engine: engineImageSource, wantDoppler: true},
+		// Traced paths aren't tracked from one update to the next, so they
+		// all stay in the impulse response
+		{engine: engineRayTracing, wantDoppler: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.engine.String(), func(t *testing.T) {
+			g := rectangularRoom(10, 6, WallProperties{absorption: uniformBands(0.5)})
+			g.engine = tt.engine
+			g.doppler = true
+			g.maxOrder = 3
+			g.imageSourceOrder = 1
+			g.addSource(AudioSource{position: Vector{3, 2}, velocity: Vector{10, 0}, gain: 1, enabled: true})
+			g.listener = Listener{heading: -math.Pi / 2}
+			g.moveListener(Vector{7, 4})
+			g.simulate()
+
+			doppler := 0
+			for _, path := range append(g.leftPaths, g.rightPaths...) {
+				if path.doppler {
+					doppler++
+				}
+			}
+			if got := doppler > 0; got != tt.wantDoppler {
+				t.Errorf("%d of %d paths rendered with Doppler, want some: %v", doppler, len(g.leftPaths)+len(g.rightPaths), tt.wantDoppler)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// fftTwiddles returns the twiddle factors of a forward transform of length n,
+// to be computed once and passed to every fft of that length.
+func fftTwiddles(n int) []complex128 {
+	twiddles := make([]complex128, n/2)
+	for k := range twiddles {
+		twiddles[k] = cmplx.Rect(1, -2*math.Pi*float64(k)/float64(n))
+	}
+	return twiddles
+}
+
+// fft computes the discrete Fourier transform of x in place, with the
+// twiddles from fftTwiddles(len(x)). The length of x must be a power of two.
+// With inverse set it computes the inverse transform, including the 1/N
+// scaling. It doesn't allocate, so it can run on the audio goroutine.
+func fft(x []complex128, twiddles []complex128, inverse bool) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		half, stride := size/2, n/size
+		for start := 0; start < n; start += size {
+			for k := 0; k < half; k++ {
+				twiddle := twiddles[k*stride]
+				if inverse {
+					twiddle = cmplx.Conj(twiddle)
+				}
+				a, b := x[start+k], x[start+k+half]*twiddle
+				x[start+k], x[start+k+half] = a+b, a-b
+			}
+		}
+	}
+
+	if inverse {
+		scale := complex(1/float64(n), 0)
+		for i := range x {
+			x[i] *= scale
+		}
+	}
+}
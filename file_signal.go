@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/faiface/beep"
+	"github.com/faiface/beep/flac"
+	"github.com/faiface/beep/mp3"
+	"github.com/faiface/beep/vorbis"
+	"github.com/faiface/beep/wav"
+)
+
+// Quality of the resampler used when a file's sample rate differs from
+// sampleRate, from 1 (linear) upwards.
+const resampleQuality = 4
+
+// fileSignal streams a decoded audio file, mixed down to mono and resampled
+// to sampleRate.
+type fileSignal struct {
+	stream  beep.StreamSeekCloser
+	samples beep.Streamer
+	gain    float64
+	buffer  [][2]float64
+
+	// Seconds of audio left from the start offset, or +Inf when looping
+	duration float64
+}
+
+// newFileSignal opens a WAV, MP3, FLAC or Ogg Vorbis file and starts playing
+// it offset into the file. With loop set it starts over from the beginning
+// when it reaches the end, otherwise it falls silent.
+func newFileSignal(path string, loop bool, gain float64, offset time.Duration) (*fileSignal, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var stream beep.StreamSeekCloser
+	var format beep.Format
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".wav":
+		stream, format, err = wav.Decode(f)
+	case ".mp3":
+		stream, format, err = mp3.Decode(f)
+	case ".flac":
+		stream, format, err = flac.Decode(f)
+	case ".ogg", ".oga":
+		stream, format, err = vorbis.Decode(f)
+	default:
+		err = fmt.Errorf("unsupported audio format %q", filepath.Ext(path))
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+
+	if start := format.SampleRate.N(offset); start > 0 {
+		if start >= stream.Len() && stream.Len() > 0 {
+			start %= stream.Len()
+		}
+		if err := stream.Seek(start); err != nil {
+			stream.Close()
+			return nil, fmt.Errorf("seeking %s to %v: %w", path, offset, err)
+		}
+	}
+
+	duration := format.SampleRate.D(stream.Len() - stream.Position()).Seconds()
+	var samples beep.Streamer = stream
+	if loop {
+		duration = math.Inf(1)
+		samples = beep.Loop(-1, stream)
+	}
+	if format.SampleRate != sampleRate {
+		samples = beep.Resample(resampleQuality, format.SampleRate, sampleRate, samples)
+	}
+	return &fileSignal{stream: stream, samples: samples, gain: gain, duration: duration}, nil
+}
+
+func (s *fileSignal) read(out []float64) {
+	if cap(s.buffer) < len(out) {
+		s.buffer = make([][2]float64, len(out))
+	}
+	buffer := s.buffer[:len(out)]
+
+	filled := 0
+	for filled < len(out) {
+		n, ok := s.samples.Stream(buffer[filled:])
+		if !ok {
+			break
+		}
+		filled += n
+	}
+	for i := range out {
+		out[i] = 0
+		if i < filled {
+			out[i] = s.gain * (buffer[i][0] + buffer[i][1]) / 2
+		}
+	}
+}
+
+// Close releases the file behind the signal.
+func (s *fileSignal) Close() error {
+	return s.stream.Close()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// sampleFormat is how each output sample is encoded.
+type sampleFormat int
+
+const (
+	formatInt16 sampleFormat = iota
+	formatInt24
+	formatFloat32
+)
+
+func (f sampleFormat) String() string {
+	switch f {
+	case formatInt24:
+		return "int24"
+	case formatFloat32:
+		return "float32"
+	default:
+		return "int16"
+	}
+}
+
+// parseSampleFormat returns the sample format called int16, int24 or float32.
+func parseSampleFormat(text string) (sampleFormat, error) {
+	for _, f := range []sampleFormat{formatInt16, formatInt24, formatFloat32} {
+		if text == f.String() {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("sample format %q is neither int16, int24 nor float32", text)
+}
+
+// bytes returns the size of one sample.
+func (f sampleFormat) bytes() int {
+	switch f {
+	case formatInt24:
+		return 3
+	case formatFloat32:
+		return 4
+	default:
+		return 2
+	}
+}
+
+// put encodes x, with full scale at ±1, as one little-endian sample at the
+// start of buf. Integer formats round x to the nearest step and clip it.
+func (f sampleFormat) put(buf []byte, x float64) {
+	switch f {
+	case formatInt24:
+		const fullScale = 1<<23 - 1
+		value := int32(math.Max(-fullScale-1, math.Min(fullScale, math.Round(x*fullScale))))
+		buf[0], buf[1], buf[2] = byte(value), byte(value>>8), byte(value>>16)
+	case formatFloat32:
+		binary.LittleEndian.PutUint32(buf, math.Float32bits(float32(x)))
+	default:
+		value := int16(math.Max(math.MinInt16, math.Min(math.MaxInt16, math.Round(x*math.MaxInt16))))
+		binary.LittleEndian.PutUint16(buf, uint16(value))
+	}
+}
+
+// outputFormat is the audio the sink, the audio device or a WAV file, takes.
+// Rendering runs at sampleRate whatever the format; the output is converted
+// to the format's rate and channels on the way to the sink.
+type outputFormat struct {
+	rate     int
+	sample   sampleFormat
+	channels int
+}
+
+// Output sample rates the resampler is meant for.
+var outputRates = []int{44100, 48000, 96000}
+
+// checkRate returns an error unless rate is one of outputRates.
+func checkRate(rate int) error {
+	for _, r := range outputRates {
+		if rate == r {
+			return nil
+		}
+	}
+	return fmt.Errorf("sample rate %d Hz is neither 44100, 48000 nor 96000", rate)
+}
+
+// parseChannels returns the channel count called mono, stereo or given as a
+// number, which must be 1 or match the rendered channels.
+func parseChannels(text string, rendered int) (int, error) {
+	channels := map[string]int{"mono": 1, "stereo": 2}[text]
+	if channels == 0 {
+		var err error
+		if channels, err = strconv.Atoi(text); err != nil || channels < 1 {
+			return 0, fmt.Errorf("channel count %q is neither mono, stereo nor a positive number", text)
+		}
+	}
+	if channels != 1 && channels != rendered {
+		return 0, fmt.Errorf("the output is rendered with %d channels and can only be mixed down to mono; choose the layout with -speakers or -ambisonics", rendered)
+	}
+	return channels, nil
+}
+
+// frameBytes returns the size of one frame.
+func (f outputFormat) frameBytes() int {
+	return f.channels * f.sample.bytes()
+}
+
+// frames returns the number of frames that play for the given duration.
+func (f outputFormat) frames(d time.Duration) int {
+	return int(d.Seconds() * float64(f.rate))
+}
+
+// remix writes the rendered channels into out, which has either as many
+// channels or a single one that gets their average.
+func remix(in, out [][]float64) {
+	if len(out) == len(in) {
+		for ch := range out {
+			copy(out[ch], in[ch])
+		}
+		return
+	}
+	clear(out[0])
+	for _, channel := range in {
+		for i, x := range channel {
+			out[0][i] += x / float64(len(in))
+		}
+	}
+}
+
+// convert turns whole channels rendered at sampleRate into the format's
+// channels and rate.
+func (f outputFormat) convert(channels [][]float64) [][]float64 {
+	if f.channels != len(channels) {
+		mixed := [][]float64{make([]float64, len(channels[0]))}
+		remix(channels, mixed)
+		channels = mixed
+	}
+	return resample(channels, sampleRate, f.rate)
+}
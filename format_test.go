@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestSampleFormatPut(t *testing.T) {
+	tests := []struct {
+		format sampleFormat
+		x      float64
+		want   []byte
+	}{
+		{formatInt16, 0.5, []byte{0x00, 0x40}},
+		{formatInt16, -1, []byte{0x01, 0x80}},
+		{formatInt16, 2, []byte{0xFF, 0x7F}}, // clipped
+		{formatInt24, 0.5, []byte{0x00, 0x00, 0x40}},
+		{formatInt24, -2, []byte{0x00, 0x00, 0x80}}, // clipped
+		{formatFloat32, 2, binary.LittleEndian.AppendUint32(nil, math.Float32bits(2))},
+	}
+	for _, tt := range tests {
+		buf := make([]byte, tt.format.bytes())
+		tt.format.put(buf, tt.x)
+		if !bytes.Equal(buf, tt.want) {
+			t.Errorf("%v put(%v) = % x, want % x", tt.format, tt.x, buf, tt.want)
+		}
+	}
+}
+
+func TestWriteWAVInt24(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeWAV(&buf, 48000, formatInt24, [][]float64{{0.5}, {-0.5}}, 0); err != nil {
+		t.Fatalf("writeWAV() error = %v", err)
+	}
+	data := buf.Bytes()
+	if len(data) != 68+2*3 {
+		t.Fatalf("writeWAV() wrote %d bytes, want %d", len(data), 68+2*3)
+	}
+	if tag := binary.LittleEndian.Uint16(data[20:22]); tag != 0xFFFE {
+		t.Errorf("format tag = %#x, want the extensible format", tag)
+	}
+	if rate := binary.LittleEndian.Uint32(data[24:28]); rate != 48000 {
+		t.Errorf("sample rate = %d, want 48000", rate)
+	}
+	if bits := binary.LittleEndian.Uint16(data[34:36]); bits != 24 {
+		t.Errorf("bits per sample = %d, want 24", bits)
+	}
+	if subFormat := data[44]; subFormat != 1 {
+		t.Errorf("sub-format = %d, want integer PCM", subFormat)
+	}
+	if !bytes.Equal(data[68:], []byte{0x00, 0x00, 0x40, 0x00, 0x00, 0xC0}) {
+		t.Errorf("samples = % x", data[68:])
+	}
+}
+
+func TestParseChannels(t *testing.T) {
+	tests := []struct {
+		text     string
+		rendered int
+		want     int
+		wantErr  bool
+	}{
+		{"mono", 2, 1, false},
+		{"stereo", 2, 2, false},
+		{"6", 6, 6, false},
+		{"1", 6, 1, false},
+		{"stereo", 6, 0, true},
+		{"surround", 2, 0, true},
+		{"0", 2, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseChannels(tt.text, tt.rendered)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseChannels(%q, %d) = %d, %v, want %d", tt.text, tt.rendered, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultLevel = 0.5 // Peak level of the built-in signals
+	// Length in seconds of the fade at the end of each sine sweep, which keeps
+	// the cut to silence from clicking.
+	sweepFade = 0.005
+)
+
+// generatorTypes are the built-in signals a source can play, in the order the
+// UI cycles through them.
+var generatorTypes = []string{"sine", "white", "pink", "sweep", "impulse", "clicks", "multitone"}
+
+// generatorSpec selects a built-in signal and its parameters. Unset
+// parameters take their defaults. Times are in seconds and frequencies in Hz.
+type generatorSpec struct {
+	Type        string    `json:"type"`
+	Level       float64   `json:"level"`       // peak level, defaultLevel if unset
+	Frequency   float64   `json:"frequency"`   // sine
+	Frequencies []float64 `json:"frequencies"` // multitone, the octave band centres if unset
+	From        float64   `json:"from"`        // sweep start, 20 Hz if unset
+	To          float64   `json:"to"`          // sweep end, 20 kHz if unset
+	Length      float64   `json:"length"`      // sweep length, 5 s if unset
+	Gap         float64   `json:"gap"`         // silence after each sweep
+	Period      float64   `json:"period"`      // click interval, 0.5 s if unset
+	Seed        int64     `json:"seed"`        // noise
+}
+
+// parseGeneratorSpec reads a generator from the command line form
+// type[:key=value,...], such as "pink" or "sweep:from=50,to=10000,length=3".
+// Multitone frequencies are separated by slashes.
+func parseGeneratorSpec(text string) (generatorSpec, error) {
+	kind, params, _ := strings.Cut(text, ":")
+	spec := generatorSpec{Type: kind}
+	if params == "" {
+		return spec, nil
+	}
+
+	for _, param := range strings.Split(params, ",") {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return spec, fmt.Errorf("signal parameter %q is not key=value", param)
+		}
+		if key == "frequencies" {
+			for _, field := range strings.Split(value, "/") {
+				frequency, err := strconv.ParseFloat(field, 64)
+				if err != nil {
+					return spec, fmt.Errorf("signal parameter %s: %w", key, err)
+				}
+				spec.Frequencies = append(spec.Frequencies, frequency)
+			}
+			continue
+		}
+		if key == "seed" {
+			seed, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return spec, fmt.Errorf("signal parameter %s: %w", key, err)
+			}
+			spec.Seed = seed
+			continue
+		}
+
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return spec, fmt.Errorf("signal parameter %s: %w", key, err)
+		}
+		switch key {
+		case "level":
+			spec.Level = number
+		case "frequency":
+			spec.Frequency = number
+		case "from":
+			spec.From = number
+		case "to":
+			spec.To = number
+		case "length":
+			spec.Length = number
+		case "gap":
+			spec.Gap = number
+		case "period":
+			spec.Period = number
+		default:
+			return spec, fmt.Errorf("unknown signal parameter %q", key)
+		}
+	}
+	return spec, nil
+}
+
+// signal returns a fresh generator for the spec.
+func (s generatorSpec) signal() (signal, error) {
+	level := s.Level
+	if level == 0 {
+		level = defaultLevel
+	}
+
+	switch s.Type {
+	case "sine":
+		frequency := orDefault(s.Frequency, sineFreq)
+		if frequency <= 0 || frequency >= sampleRate/2 {
+			return nil, fmt.Errorf("sine frequency %v Hz is outside (0, %v)", frequency, sampleRate/2)
+		}
+		return &sineSignal{frequency: frequency, amplitude: level}, nil
+	case "white":
+		return &whiteNoise{level: level, random: rand.New(rand.NewSource(s.Seed))}, nil
+	case "pink":
+		return &pinkNoise{white: whiteNoise{level: 1, random: rand.New(rand.NewSource(s.Seed))}, level: level}, nil
+	case "sweep":
+		from, to, length := orDefault(s.From, 20), orDefault(s.To, 20000), orDefault(s.Length, 5)
+		if from <= 0 || to <= from || to >= sampleRate/2 {
+			return nil, fmt.Errorf("sweep from %v Hz to %v Hz must rise within (0, %v)", from, to, sampleRate/2)
+		}
+		if length <= 2*sweepFade || s.Gap < 0 {
+			return nil, fmt.Errorf("sweep length %v s or gap %v s is too short", length, s.Gap)
+		}
+		return &sineSweep{from: from, to: to, length: length, gap: s.Gap, level: level}, nil
+	case "impulse":
+		return &impulseTrain{level: level}, nil
+	case "clicks":
+		period := int(math.Round(orDefault(s.Period, 0.5) * sampleRate))
+		if period < 1 {
+			return nil, fmt.Errorf("click period %v s is shorter than a sample", s.Period)
+		}
+		return &impulseTrain{level: level, period: period}, nil
+	case "multitone":
+		frequencies := s.Frequencies
+		if len(frequencies) == 0 {
+			frequencies = bandFrequencies[:]
+		}
+		for _, frequency := range frequencies {
+			if frequency <= 0 || frequency >= sampleRate/2 {
+				return nil, fmt.Errorf("multitone frequency %v Hz is outside (0, %v)", frequency, sampleRate/2)
+			}
+		}
+		return newMultitone(frequencies, level), nil
+	}
+	return nil, fmt.Errorf("unknown signal %q, want one of %s", s.Type, strings.Join(generatorTypes, ", "))
+}
+
+// orDefault returns value, or fallback if value is unset.
+func orDefault(value, fallback float64) float64 {
+	if value == 0 {
+		return fallback
+	}
+	return value
+}
+
+// whiteNoise is uniformly distributed noise between -level and level.
+type whiteNoise struct {
+	level  float64
+	random *rand.Rand
+}
+
+func (n *whiteNoise) read(out []float64) {
+	for i := range out {
+		out[i] = n.level * (2*n.random.Float64() - 1)
+	}
+}
+
+// pinkNoise is white noise shaped to fall off at 3 dB per octave by Paul
+// Kellet's refined filter, which is accurate to within 0.05 dB above 9 Hz at
+// 44.1 kHz.
+type pinkNoise struct {
+	white whiteNoise
+	level float64
+	b     [7]float64
+}
+
+// Scales the filter's output to roughly the white noise's peak level.
+const pinkNoiseGain = 0.11
+
+func (n *pinkNoise) read(out []float64) {
+	n.white.read(out)
+	b := &n.b
+	for i, w := range out {
+		b[0] = 0.99886*b[0] + w*0.0555179
+		b[1] = 0.99332*b[1] + w*0.0750759
+		b[2] = 0.96900*b[2] + w*0.1538520
+		b[3] = 0.86650*b[3] + w*0.3104856
+		b[4] = 0.55000*b[4] + w*0.5329522
+		b[5] = -0.7616*b[5] - w*0.0168980
+		pink := b[0] + b[1] + b[2] + b[3] + b[4] + b[5] + b[6] + w*0.5362
+		b[6] = w * 0.115926
+		out[i] = n.level * pinkNoiseGain * pink
+	}
+}
+
+// sineSweep repeats an exponential sine sweep from from to to Hz over length
+// seconds, each followed by gap seconds of silence. Its instantaneous
+// frequency rises by the same number of octaves per second throughout, as in
+// Farina's swept-sine measurement method.
+type sineSweep struct {
+	from, to, length, gap float64
+	level                 float64
+	n                     int
+}
+
+func (s *sineSweep) read(out []float64) {
+	rate := math.Log(s.to / s.from)
+	sweepSamples := int(s.length * sampleRate)
+	period := sweepSamples + int(s.gap*sampleRate)
+	fadeSamples := sweepFade * sampleRate
+	for i := range out {
+		out[i] = 0
+		if k := s.n % period; k < sweepSamples {
+			t := float64(k) / sampleRate
+			phase := 2 * math.Pi * s.from * s.length / rate * (math.Exp(t*rate/s.length) - 1)
+			out[i] = s.level * math.Sin(phase)
+			if remaining := float64(sweepSamples - k); remaining < fadeSamples {
+				out[i] *= 0.5 - 0.5*math.Cos(math.Pi*remaining/fadeSamples)
+			}
+		}
+		s.n++
+	}
+}
+
+// impulseTrain is a single-sample impulse at the start and then, if period is
+// set, every period samples. Without a period it is a lone Dirac impulse.
+type impulseTrain struct {
+	level  float64
+	period int
+	n      int
+}
+
+func (t *impulseTrain) read(out []float64) {
+	for i := range out {
+		out[i] = 0
+		if t.n == 0 || (t.period > 0 && t.n%t.period == 0) {
+			out[i] = t.level
+		}
+		t.n++
+	}
+}
+
+// multitone is a sum of sines with Schroeder phases, which keep its crest
+// factor low. Each tone has level/len(tones) amplitude, so the sum never
+// exceeds level.
+type multitone struct {
+	tones []sineSignal
+	tone  []float64
+}
+
+func newMultitone(frequencies []float64, level float64) *multitone {
+	m := &multitone{}
+	count := float64(len(frequencies))
+	for k, frequency := range frequencies {
+		phase := math.Mod(-math.Pi*float64(k)*float64(k+1)/count, 2*math.Pi)
+		m.tones = append(m.tones, sineSignal{frequency: frequency, amplitude: level / count, phase: phase})
+	}
+	return m
+}
+
+func (m *multitone) read(out []float64) {
+	clear(out)
+	if cap(m.tone) < len(out) {
+		m.tone = make([]float64, len(out))
+	}
+	tone := m.tone[:len(out)]
+	for i := range m.tones {
+		m.tones[i].read(tone)
+		for j, sample := range tone {
+			out[j] += sample
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestParseGeneratorSpec(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    generatorSpec
+		wantErr bool
+	}{
+		{"pink", generatorSpec{Type: "pink"}, false},
+		{"sweep:from=50,to=10000,length=3,gap=0.5", generatorSpec{Type: "sweep", From: 50, To: 10000, Length: 3, Gap: 0.5}, false},
+		{"multitone:frequencies=100/1000,level=0.2", generatorSpec{Type: "multitone", Frequencies: []float64{100, 1000}, Level: 0.2}, false},
+		{"white:seed=7", generatorSpec{Type: "white", Seed: 7}, false},
+		{"clicks:period", generatorSpec{}, true},
+		{"clicks:rate=2", generatorSpec{}, true},
+		{"sine:frequency=loud", generatorSpec{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseGeneratorSpec(tt.text)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseGeneratorSpec(%q) error = %v, wantErr %v", tt.text, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseGeneratorSpec(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestGeneratorsStayWithinLevel(t *testing.T) {
+	for _, generator := range generatorTypes {
+		dry, err := generatorSpec{Type: generator, Level: 0.25}.signal()
+		if err != nil {
+			t.Fatalf("%s: %v", generator, err)
+		}
+		out := make([]float64, sampleRate)
+		dry.read(out)
+
+		peak := 0.0
+		for _, sample := range out {
+			peak = math.Max(peak, math.Abs(sample))
+		}
+		if peak == 0 || peak > 0.25+1e-9 {
+			t.Errorf("%s: peak %v, want within (0, 0.25]", generator, peak)
+		}
+	}
+
+	for _, spec := range []generatorSpec{
+		{Type: "hum"},
+		{Type: "sweep", From: 1000, To: 100},
+		{Type: "multitone", Frequencies: []float64{100, 30000}},
+	} {
+		if _, err := spec.signal(); err == nil {
+			t.Errorf("%+v.signal() succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestImpulseTrain(t *testing.T) {
+	tests := []struct {
+		spec generatorSpec
+		want []int // indices of the impulses in the first second
+	}{
+		{generatorSpec{Type: "impulse"}, []int{0}},
+		{generatorSpec{Type: "clicks", Period: 0.25}, []int{0, 11025, 22050, 33075}},
+	}
+
+	for _, tt := range tests {
+		dry, err := tt.spec.signal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Read in uneven blocks to check the train carries across reads
+		out := make([]float64, sampleRate)
+		dry.read(out[:1000])
+		dry.read(out[1000:])
+
+		var got []int
+		for i, sample := range out {
+			if sample != 0 {
+				got = append(got, i)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: impulses at %v, want %v", tt.spec.Type, got, tt.want)
+		}
+	}
+}
+
+func TestSineSweepFrequencyRisesExponentially(t *testing.T) {
+	dry, err := generatorSpec{Type: "sweep", From: 100, To: 1600, Length: 2, Gap: 1}.signal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]float64, 3*sampleRate)
+	dry.read(out)
+
+	// Four octaves in two seconds: the frequency doubles every half second,
+	// which shows as the rate of zero crossings around each time
+	for i, want := range []float64{200, 400, 800} {
+		centre := int(0.5 * float64(i+1) * sampleRate)
+		window := sampleRate / 20
+		crossings := 0
+		for j := centre - window/2; j < centre+window/2; j++ {
+			if (out[j] < 0) != (out[j+1] < 0) {
+				crossings++
+			}
+		}
+		got := float64(crossings) / 2 / (float64(window) / sampleRate)
+		if math.Abs(got-want)/want > 0.1 {
+			t.Errorf("frequency at %.2f s = %v Hz, want about %v Hz", float64(centre)/sampleRate, got, want)
+		}
+	}
+
+	for _, sample := range out[2*sampleRate:] {
+		if sample != 0 {
+			t.Fatal("sweep is not silent during its gap")
+		}
+	}
+}
+
+func TestPinkNoiseFallsByThreeDecibelsPerOctave(t *testing.T) {
+	dry, _ := generatorSpec{Type: "pink", Seed: 1}.signal()
+	out := make([]float64, 4*sampleRate)
+	dry.read(out)
+
+	energy := func(b int) float64 {
+		total := 0.0
+		for _, sample := range octaveBand(out, b) {
+			total += sample * sample
+		}
+		return total
+	}
+	// Octave bands double in width, so pink noise has the same energy in each.
+	// The lowest and highest bands extend to DC and Nyquist and are skipped
+	for b := 2; b < numBands-1; b++ {
+		ratio := 10 * math.Log10(energy(b)/energy(b-1))
+		if math.Abs(ratio) > 1.5 {
+			t.Errorf("band %v Hz has %.1f dB more energy than the band below, want about 0", bandFrequencies[b], ratio)
+		}
+	}
+}
@@ -5,6 +5,8 @@ go 1.21.4
 require (
 	github.com/faiface/beep v1.1.0
 	github.com/faiface/pixel v0.10.0
+	github.com/hajimehoshi/ebiten/v2 v2.7.9
+	github.com/hajimehoshi/oto/v2 v2.4.2
 	golang.org/x/image v0.20.0
 )
 
@@ -20,11 +22,14 @@ require (
 	github.com/go-gl/gl v0.0.0-20231021071112-07e5d0ea2e71 // indirect
 	github.com/go-gl/glfw/v3.3/glfw v0.0.0-20240506104042-037f3cc74f2a // indirect
 	github.com/go-gl/mathgl v0.0.0-20190416160123-c4601bc793c7 // indirect
-	github.com/hajimehoshi/ebiten/v2 v2.7.9 // indirect
 	github.com/hajimehoshi/go-mp3 v0.3.4 // indirect
 	github.com/hajimehoshi/oto v1.0.1 // indirect
-	github.com/hajimehoshi/oto/v2 v2.4.2 // indirect
+	github.com/icza/bitio v1.0.0 // indirect
 	github.com/jezek/xgb v1.1.1 // indirect
+	github.com/jfreymuth/oggvorbis v1.0.5 // indirect
+	github.com/jfreymuth/vorbis v1.0.2 // indirect
+	github.com/mewkiz/flac v1.0.7 // indirect
+	github.com/mewkiz/pkg v0.0.0-20190919212034-518ade7978e2 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	golang.org/x/exp/shiny v0.0.0-20240707233637-46b078467d37 // indirect
 	golang.org/x/mobile v0.0.0-20231127183840-76ac6878050a // indirect
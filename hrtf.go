@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hrtf is a set of head-related impulse responses measured around a listener.
+type hrtf struct {
+	measurements []hrir
+	interpolate  bool
+}
+
+// hrir is the pair of impulse responses for sound arriving from one direction.
+// Azimuth is in degrees counterclockwise from straight ahead, elevation in
+// degrees above the horizontal plane.
+type hrir struct {
+	azimuth, elevation float64
+	left, right        []float64
+}
+
+// sofaFile is the JSON export of an AES69 SOFA file of the SimpleFreeFieldHRIR
+// convention, with each netCDF variable stored under "variables" as its
+// attributes and its values as nested arrays.
+type sofaFile struct {
+	Variables map[string]sofaVariable `json:"variables"`
+}
+
+type sofaVariable struct {
+	Attributes map[string]string `json:"attributes"`
+	Values     json.RawMessage   `json:"values"`
+}
+
+// loadHRTF reads an HRTF set from the JSON export of a SOFA file. SOFA files
+// themselves are HDF5, which can't be read without cgo, so they have to be
+// converted to JSON first.
+func loadHRTF(path string) (*hrtf, error) {
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".sofa" {
+		return nil, fmt.Errorf("%s: HDF5 SOFA files aren't supported, export it to JSON first", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sofa sofaFile
+	if err := json.Unmarshal(data, &sofa); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	h, err := sofa.hrtf()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return h, nil
+}
+
+// hrtf extracts the measurements from the SOFA variables.
+func (s sofaFile) hrtf() (*hrtf, error) {
+	var rates []float64
+	if err := s.values("Data.SamplingRate", &rates); err != nil {
+		var rate float64
+		if s.values("Data.SamplingRate", &rate) != nil {
+			return nil, err
+		}
+		rates = []float64{rate}
+	}
+	if len(rates) != 1 || rates[0] != sampleRate {
+		return nil, fmt.Errorf("HRIRs are sampled at %v Hz, want %v Hz", rates, sampleRate)
+	}
+
+	var positions [][]float64
+	if err := s.values("SourcePosition", &positions); err != nil {
+		return nil, err
+	}
+	var irs [][][]float64
+	if err := s.values("Data.IR", &irs); err != nil {
+		return nil, err
+	}
+	if len(irs) != len(positions) {
+		return nil, fmt.Errorf("%d source positions but %d impulse responses", len(positions), len(irs))
+	}
+
+	// Broadband delays in samples, either one per receiver or one per
+	// measurement and receiver
+	var delays [][]float64
+	if _, ok := s.Variables["Data.Delay"]; ok {
+		if err := s.values("Data.Delay", &delays); err != nil {
+			return nil, err
+		}
+	}
+
+	cartesian := strings.EqualFold(s.Variables["SourcePosition"].Attributes["Type"], "cartesian")
+	h := &hrtf{measurements: make([]hrir, len(positions)), interpolate: true}
+	for m, position := range positions {
+		if len(position) < 2 {
+			return nil, fmt.Errorf("source position %d has %d coordinates", m, len(position))
+		}
+		if len(irs[m]) != 2 {
+			return nil, fmt.Errorf("measurement %d has %d receivers, want 2", m, len(irs[m]))
+		}
+
+		azimuth, elevation := position[0], position[1]
+		if cartesian {
+			if len(position) < 3 {
+				return nil, fmt.Errorf("source position %d has %d coordinates", m, len(position))
+			}
+			x, y, z := position[0], position[1], position[2]
+			azimuth = math.Atan2(y, x) * 180 / math.Pi
+			elevation = math.Atan2(z, math.Hypot(x, y)) * 180 / math.Pi
+		}
+
+		measurement := hrir{azimuth: azimuth, elevation: elevation, left: irs[m][0], right: irs[m][1]}
+		if len(delays) > 0 {
+			delay := delays[min(m, len(delays)-1)]
+			if len(delay) == 2 {
+				measurement.left = delayed(measurement.left, delay[0])
+				measurement.right = delayed(measurement.right, delay[1])
+			}
+		}
+		h.measurements[m] = measurement
+	}
+	if len(h.measurements) == 0 {
+		return nil, fmt.Errorf("no measurements")
+	}
+	return h, nil
+}
+
+// values decodes the values of the named variable into v.
+func (s sofaFile) values(name string, v interface{}) error {
+	variable, ok := s.Variables[name]
+	if !ok {
+		return fmt.Errorf("missing variable %s", name)
+	}
+	if err := json.Unmarshal(variable.Values, v); err != nil {
+		return fmt.Errorf("variable %s: %w", name, err)
+	}
+	return nil
+}
+
+// delayed returns ir shifted later by the given number of samples, rounded to
+// a whole sample.
+func delayed(ir []float64, samples float64) []float64 {
+	shift := int(math.Round(samples))
+	if shift <= 0 {
+		return ir
+	}
+	return append(make([]float64, shift), ir...)
+}
+
+// azimuthOf returns the azimuth in degrees of a direction relative to a
+// listener facing towards -y with their left ear towards -x.
+func azimuthOf(direction Vector) float64 {
+	return math.Atan2(-direction.x, -direction.y) * 180 / math.Pi
+}
+
+// response returns the impulse response of one ear for sound arriving from
+// direction, relative to the listener. The measurements are taken from the
+// ring closest to the horizontal plane, and either the nearest one is used or
+// the two either side of the direction are blended.
+func (h *hrtf) response(direction Vector, isLeft bool) []float64 {
+	azimuth := azimuthOf(direction)
+
+	ring := h.measurements[0].elevation
+	for _, m := range h.measurements {
+		if math.Abs(m.elevation) < math.Abs(ring) {
+			ring = m.elevation
+		}
+	}
+
+	// The closest measurements clockwise and counterclockwise of the
+	// direction, by their azimuth offset wrapped to (-180°, 180°]
+	below, above := -1, -1
+	belowGap, aboveGap := math.Inf(1), math.Inf(1)
+	for i, m := range h.measurements {
+		if m.elevation != ring {
+			continue
+		}
+		offset := 180 - math.Mod(540-(m.azimuth-azimuth), 360)
+		if offset >= 0 && offset < aboveGap {
+			above, aboveGap = i, offset
+		}
+		if offset < 0 && -offset < belowGap {
+			below, belowGap = i, -offset
+		}
+	}
+
+	ear := func(i int) []float64 {
+		if isLeft {
+			return h.measurements[i].left
+		}
+		return h.measurements[i].right
+	}
+	switch {
+	case above == -1:
+		return ear(below)
+	case below == -1:
+		return ear(above)
+	case !h.interpolate && belowGap < aboveGap:
+		return ear(below)
+	case !h.interpolate:
+		return ear(above)
+	}
+
+	a, b := ear(below), ear(above)
+	weight := belowGap / (belowGap + aboveGap)
+	blend := make([]float64, max(len(a), len(b)))
+	for i := range blend {
+		if i < len(a) {
+			blend[i] += (1 - weight) * a[i]
+		}
+		if i < len(b) {
+			blend[i] += weight * b[i]
+		}
+	}
+	return blend
+}
+
+// length returns the number of samples in the longest impulse response.
+func (h *hrtf) length() int {
+	n := 0
+	for _, m := range h.measurements {
+		n = max(n, len(m.left), len(m.right))
+	}
+	return n
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// writeSOFA writes a SOFA JSON export with one-tap HRIRs whose left tap is the
+// azimuth of the measurement and whose right tap is its elevation plus one,
+// delayed by two samples.
+func writeSOFA(t *testing.T, rate float64) string {
+	t.Helper()
+	data := `{
+		"attributes": {"SOFAConventions": "SimpleFreeFieldHRIR"},
+		"variables": {
+			"Data.SamplingRate": {"attributes": {"Units": "hertz"}, "values": [` + strconv.FormatFloat(rate, 'f', -1, 64) + `]},
+			"SourcePosition": {
+				"attributes": {"Type": "spherical", "Units": "degree, degree, metre"},
+				"values": [[0, 0, 1.2], [90, 0, 1.2], [180, 0, 1.2], [270, 0, 1.2], [45, 30, 1.2]]
+			},
+			"Data.IR": {"values": [[[0], [1]], [[90], [1]], [[180], [1]], [[270], [1]], [[45], [31]]]},
+			"Data.Delay": {"values": [[0, 2]]}
+		}
+	}`
+	path := filepath.Join(t.TempDir(), "hrtf.json")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHRTFResponse(t *testing.T) {
+	h, err := loadHRTF(writeSOFA(t, sampleRate))
+	if err != nil {
+		t.Fatalf("loadHRTF() error = %v", err)
+	}
+
+	diagonal := 1 / math.Sqrt2
+	tests := []struct {
+		name        string
+		direction   Vector
+		interpolate bool
+		want        float64
+	}{
+		{"ahead", Vector{0, -1}, true, 0},
+		{"left", Vector{-1, 0}, true, 90},
+		{"behind", Vector{0, 1}, false, 180},
+		{"right", Vector{1, 0}, true, 270},
+		{"ahead left", Vector{-diagonal, -diagonal}, true, 45},
+		{"nearly left", Vector{-0.9, -0.1}, false, 90},
+		{"behind right", Vector{diagonal, diagonal}, true, 225},
+	}
+	for _, tt := range tests {
+		h.interpolate = tt.interpolate
+		left := h.response(tt.direction, true)
+		if len(left) != 1 || math.Abs(left[0]-tt.want) > 1e-9 {
+			t.Errorf("%s: left response %v, want [%v]", tt.name, left, tt.want)
+		}
+		// Only the horizontal ring is used, and the right ear is delayed
+		if right := h.response(tt.direction, false); len(right) != 3 || right[2] != 1 {
+			t.Errorf("%s: right response %v, want [0 0 1]", tt.name, right)
+		}
+	}
+	if h.length() != 3 {
+		t.Errorf("length() = %d, want 3", h.length())
+	}
+}
+
+func TestLoadHRTFErrors(t *testing.T) {
+	if _, err := loadHRTF(writeSOFA(t, 48000)); err == nil {
+		t.Errorf("loadHRTF() with HRIRs at another sample rate returned no error")
+	}
+	if _, err := loadHRTF(filepath.Join(t.TempDir(), "subject.sofa")); err == nil {
+		t.Errorf("loadHRTF() on an HDF5 SOFA file returned no error")
+	}
+}
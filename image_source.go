@@ -0,0 +1,172 @@
+package main
+
+import "math"
+
+// propagationEngine selects how Update finds the paths from the source to the
+// listener.
+type propagationEngine int
+
+const (
+	// engineHybrid takes specular paths up to g.imageSourceOrder from the
+	// image sources and everything else from the ray tracer.
+	engineHybrid propagationEngine = iota
+	engineRayTracing
+	engineImageSource
+)
+
+func (e propagationEngine) String() string {
+	switch e {
+	case engineRayTracing:
+		return "ray tracing"
+	case engineImageSource:
+		return "image sources"
+	default:
+		return "hybrid"
+	}
+}
+
+// imageSource is the audio source mirrored across a sequence of walls.
+type imageSource struct {
+	position Vector
+	wall     int // wall the parent was mirrored across, -1 for the real source
+	parent   int // index of the parent image, -1 for the real source
+	order    int
+}
+
+func mirrorAcrossWall(p Vector, wall Wall) Vector {
+	wallDir := Vector{wall.end.x - wall.start.x, wall.end.y - wall.start.y}.normalize()
+	normal := Vector{-wallDir.y, wallDir.x}
+	dist := dot(Vector{p.x - wall.start.x, p.y - wall.start.y}, normal)
+	return Vector{p.x - 2*dist*normal.x, p.y - 2*dist*normal.y}
+}
+
+// segmentIntersection returns the point where the lines through a-b and c-d
+// cross, along with its parameter on a-b and on c-d. It returns false if the
+// lines are parallel.
+func segmentIntersection(a, b, c, d Vector) (Vector, float64, float64, bool) {
+	r := Vector{b.x - a.x, b.y - a.y}
+	q := Vector{d.x - c.x, d.y - c.y}
+	den := r.x*q.y - r.y*q.x
+	if math.Abs(den) < 1e-12 {
+		return Vector{}, 0, 0, false
+	}
+	ac := Vector{c.x - a.x, c.y - a.y}
+	s := (ac.x*q.y - ac.y*q.x) / den
+	t := (ac.x*r.y - ac.y*r.x) / den
+	return Vector{a.x + s*r.x, a.y + s*r.y}, s, t, true
+}
+
+// buildImageSources mirrors source across the walls up to maxOrder times. The
+// real source is the first entry and parents always come before their
+// children.
+func (g *Game) buildImageSources(source Vector, maxOrder int) []imageSource {
+	images := []imageSource{{position: source, wall: -1, parent: -1}}
+	for i := 0; i < len(images); i++ {
+		if images[i].order == maxOrder {
+			continue
+		}
+		for w, wall := range g.walls {
+			if w == images[i].wall {
+				continue // mirroring back across the same wall gives the parent
+			}
+			images = append(images, imageSource{
+				position: mirrorAcrossWall(images[i].position, wall),
+				wall:     w,
+				parent:   i,
+				order:    images[i].order + 1,
+			})
+		}
+	}
+	return images
+}
+
+// imageSourcePath walks back from the receiver to the real source through the
+// walls the image was built from. It returns the path's corners, receiver
+// first, and the band energy left after the reflections and any walls crossed
+// on the way. It returns false if a reflection point misses its wall.
+func (g *Game) imageSourcePath(images []imageSource, index int, receiver Vector) ([]Vector, bands, bool) {
+	energy := uniformBands(1.0)
+	points := []Vector{receiver}
+	current, lastWall := receiver, -1
+
+	i := index
+	for ; images[i].parent != -1; i = images[i].parent {
+		wall := g.walls[images[i].wall]
+		hit, s, t, ok := segmentIntersection(current, images[i].position, wall.start, wall.end)
+		if !ok || s <= 1e-9 || s > 1 || t < 0 || t > 1 {
+			return nil, energy, false
+		}
+
+		energy = energy.mul(g.transmissionAlong(current, hit, lastWall, images[i].wall))
+		energy = energy.mul(wall.properties.absorption.complement()).
+			mul(wall.properties.transparency.complement()).
+			scale(1.0 - wall.properties.roughness)
+		points = append(points, hit)
+		current, lastWall = hit, images[i].wall
+	}
+
+	energy = energy.mul(g.transmissionAlong(current, images[i].position, lastWall))
+	points = append(points, images[i].position)
+	return points, energy, true
+}
+
+// transmissionAlong returns the share of energy that passes straight through
+// the walls crossed by the segment a-b, skipping the given walls. The share
+// each wall scatters into its transmission lobe is left to the ray tracer.
+func (g *Game) transmissionAlong(a, b Vector, ignore ...int) bands {
+	factor := uniformBands(1.0)
+	ray := Ray{a, Vector{b.x - a.x, b.y - a.y}}
+	g.wallIndex.alongRay(ray, 1, func(i int) {
+		for _, skip := range ignore {
+			if i == skip {
+				return
+			}
+		}
+		wall := g.walls[i]
+		if _, s, t, ok := segmentIntersection(a, b, wall.start, wall.end); ok && s > 1e-9 && s < 1-1e-9 && t >= 0 && t <= 1 {
+			factor = factor.mul(wall.properties.transparency).scale(1.0 - wall.properties.transmissionRoughness)
+		}
+	})
+	return factor
+}
+
+// addImageSourcePaths adds an AudioPath per ear for every image source of the
+// given source up to g.imageSourceOrder that is valid for the listener, and
+// records the paths for drawing.
+func (g *Game) addImageSourcePaths(source int) {
+	images := g.buildImageSources(g.audioSources[source].position, g.imageSourceOrder)
+	speedOfSound := g.atmosphere.speedOfSound()
+
+	for i, image := range images {
+		points, energy, ok := g.imageSourcePath(images, i, g.listener.position)
+		if !ok || energy.max() < 1e-6 {
+			continue
+		}
+		g.rayPathPoints = append(g.rayPathPoints, make([]RayPathPoint, len(points)))
+		for j, point := range points {
+			g.rayPathPoints[len(g.rayPathPoints)-1][j] = RayPathPoint{point, energy.mean()}
+		}
+
+		pathLength := distance(g.listener.position, image.position)
+		energy = energy.scale(spreadingLoss(pathLength)).mul(g.airAttenuation(pathLength))
+		direction := Vector{points[1].x - points[0].x, points[1].y - points[0].y}.normalize()
+		velocity := g.imageVelocity(images, i, g.audioSources[source].velocity)
+
+		g.leftPaths = append(g.leftPaths, AudioPath{
+			source:    source,
+			delay:     distance(image.position, g.listener.leftEar) / speedOfSound,
+			delayRate: delayRate(image.position, velocity, g.listener.leftEar, g.listener.velocity, speedOfSound),
+			energy:    energy,
+			direction: direction,
+			imageKey:  i + 1,
+		})
+		g.rightPaths = append(g.rightPaths, AudioPath{
+			source:    source,
+			delay:     distance(image.position, g.listener.rightEar) / speedOfSound,
+			delayRate: delayRate(image.position, velocity, g.listener.rightEar, g.listener.velocity, speedOfSound),
+			energy:    energy,
+			direction: direction,
+			imageKey:  i + 1,
+		})
+	}
+}
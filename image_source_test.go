@@ -0,0 +1,142 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// rectangularRoom returns a closed room of width by height metres whose walls
+// all have the given properties, with no air absorption.
+func rectangularRoom(width, height float64, properties WallProperties) *Game {
+	g := &Game{
+		walls: []Wall{
+			{Vector{0, 0}, Vector{width, 0}, properties},
+			{Vector{width, 0}, Vector{width, height}, properties},
+			{Vector{width, height}, Vector{0, height}, properties},
+			{Vector{0, height}, Vector{0, 0}, properties},
+		},
+		atmosphere: standardAtmosphere(),
+		view:       View{pixelsPerMeter: 100},
+	}
+	g.getWallEdges()
+	g.buildSceneIndex()
+	return g
+}
+
+func TestImageSourcesFirstOrder(t *testing.T) {
+	g := rectangularRoom(10, 6, WallProperties{})
+	source := Vector{3, 2}
+	listener := Vector{7, 4}
+
+	images := g.buildImageSources(source, 1)
+	if len(images) != 5 {
+		t.Fatalf("buildImageSources() got %d images, want 5", len(images))
+	}
+
+	wantImages := map[Vector]bool{
+		{3, -2}: true, // ceiling
+		{17, 2}: true, // right wall
+		{3, 10}: true, // floor
+		{-3, 2}: true, // left wall
+	}
+	for i, image := range images[1:] {
+		if !wantImages[image.position] {
+			t.Errorf("image %d at %v, not a first-order image of %v", i+1, image.position, source)
+		}
+		points, energy, ok := g.imageSourcePath(images, i+1, listener)
+		if !ok {
+			t.Errorf("image %d at %v: path not valid", i+1, image.position)
+			continue
+		}
+		if len(points) != 3 || points[0] != listener || points[2] != source {
+			t.Errorf("image %d: got path %v", i+1, points)
+		}
+		pathLength := distance(points[0], points[1]) + distance(points[1], points[2])
+		if math.Abs(pathLength-distance(listener, image.position)) > 1e-9 {
+			t.Errorf("image %d: path length %v, want %v", i+1, pathLength, distance(listener, image.position))
+		}
+		if energy != uniformBands(1.0) {
+			t.Errorf("image %d: energy %v, want lossless reflection", i+1, energy)
+		}
+	}
+}
+
+func TestImageSourceOccludedByOpaqueWall(t *testing.T) {
+	g := rectangularRoom(10, 6, WallProperties{})
+	g.walls = append(g.walls, Wall{Vector{5, 1}, Vector{5, 5}, WallProperties{}})
+	g.getWallEdges()
+	g.buildSceneIndex()
+
+	images := g.buildImageSources(Vector{3, 3}, 0)
+	_, energy, ok := g.imageSourcePath(images, 0, Vector{7, 3})
+	if !ok {
+		t.Fatalf("imageSourcePath() for the direct path returned not ok")
+	}
+	if energy.max() != 0 {
+		t.Errorf("direct path through an opaque wall got energy %v, want 0", energy)
+	}
+
+	g.walls[4].properties.transparency = uniformBands(0.25)
+	_, energy, _ = g.imageSourcePath(images, 0, Vector{7, 3})
+	if energy != uniformBands(0.25) {
+		t.Errorf("direct path through a partly transparent wall got energy %v, want 0.25", energy)
+	}
+}
+
+func TestHybridCountsTransmissionOnce(t *testing.T) {
+	// A partition that lets half the energy through, half of that straight
+	// and half into its lobe, and reflects nothing
+	partition := WallProperties{
+		absorption:            uniformBands(1),
+		transparency:          uniformBands(0.5),
+		transmissionRoughness: 0.5,
+	}
+	received := func(engine propagationEngine) (traced, images float64) {
+		g := &Game{
+			walls:            []Wall{{Vector{8, 0}, Vector{8, 10}, partition}},
+			audioSources:     []AudioSource{{position: Vector{5, 5}, enabled: true}},
+			atmosphere:       standardAtmosphere(),
+			view:             View{pixelsPerMeter: 100},
+			engine:           engine,
+			maxOrder:         3,
+			imageSourceOrder: 1,
+		}
+		g.airAbsorption = g.atmosphere.absorptionCoefficients()
+		g.moveListener(Vector{11, 5})
+		g.getWallEdges()
+		g.buildSceneIndex()
+
+		// Average a number of runs, since the lobe is sampled at random
+		const runs = 50
+		for run := 0; run < runs; run++ {
+			g.leftPaths = nil
+			for i := 0; i < numRays; i++ {
+				angle := float64(i) * 2 * math.Pi / float64(numRays)
+				g.spawnRay(rayTask{ray: Ray{g.audioSources[0].position, Vector{math.Cos(angle), math.Sin(angle)}}, intensity: uniformBands(1.0), specular: true})
+			}
+			g.traceRays()
+			for _, path := range g.leftPaths {
+				traced += path.energy[0] / runs
+			}
+		}
+		if engine == engineHybrid {
+			g.leftPaths = nil
+			g.addImageSourcePaths(0)
+			for _, path := range g.leftPaths {
+				images += path.energy[0]
+			}
+		}
+		return traced, images
+	}
+
+	// Counting the straight path twice would add almost half as much again
+	want, _ := received(engineRayTracing)
+	traced, images := received(engineHybrid)
+	if got := traced + images; math.Abs(got-want)/want > 0.15 {
+		t.Errorf("hybrid received %v (%v traced, %v from image sources), ray tracing alone %v", got, traced, images, want)
+	}
+	straight := 0.25 * spreadingLoss(distance(Vector{5, 5}, Vector{11, 5}))
+	if math.Abs(images-straight)/straight > 0.05 {
+		t.Errorf("image sources delivered %v through the partition, want %v", images, straight)
+	}
+}
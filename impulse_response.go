@@ -0,0 +1,342 @@
+package main
+
+import (
+	"math"
+)
+
+const (
+	// Half the length of the windowed-sinc kernel used to place paths at
+	// fractional sample delays.
+	fractionalDelayHalfWidth = 16
+	// Longest impulse response that is built, in seconds.
+	maxImpulseResponseSeconds = 3.0
+)
+
+// impulseResponse is a binaural impulse response sampled at sampleRate, one
+// slice of samples per ear.
+type impulseResponse struct {
+	left, right []float64
+}
+
+// buildImpulseResponse turns the traced paths from the given source into an
+// impulse response per ear. The path delays are first referred to the centre
+// of the head. With an HRTF loaded each path is then filtered by the HRIR for
+// its direction, which carries the interaural time difference itself. Without
+// one the ears differ by a level difference and a spherical-head time
+// difference.
+func (g *Game) buildImpulseResponse(source int) impulseResponse {
+	leftPaths := g.referToHeadCentre(pathsFrom(g.leftPaths, source), g.listener.leftEar, true)
+	rightPaths := g.referToHeadCentre(pathsFrom(g.rightPaths, source), g.listener.rightEar, false)
+	earLength := 1
+	if g.hrtf != nil {
+		earLength = g.hrtf.length()
+	}
+
+	length := impulseResponseLength(leftPaths, rightPaths) + earLength - 1
+	return impulseResponse{
+		left:  renderPaths(leftPaths, g.earResponse(true), length),
+		right: renderPaths(rightPaths, g.earResponse(false), length),
+	}
+}
+
+// panner spreads sound arriving from a direction over the output channels.
+type panner interface {
+	channels() int
+	// pan returns the gain of each channel for sound arriving from azimuth,
+	// in degrees anticlockwise from the listener's heading.
+	pan(azimuth float64) []float64
+}
+
+// panner returns the Ambisonic encoder or the speaker layout in use, or nil
+// for binaural output.
+func (g *Game) panner() panner {
+	switch {
+	case g.ambisonics != nil:
+		return g.ambisonics
+	case g.speakers != nil:
+		return g.speakers
+	}
+	return nil
+}
+
+// buildResponses turns the traced paths from the given source into an impulse
+// response per output channel: Ambisonic channels if g.ambisonics is set, a
+// channel per speaker if g.speakers is, or else the left and right ear.
+func (g *Game) buildResponses(source int) [][]float64 {
+	if p := g.panner(); p != nil {
+		return g.buildPannedResponse(source, p)
+	}
+	ir := g.buildImpulseResponse(source)
+	return [][]float64{ir.left, ir.right}
+}
+
+// buildPannedResponse renders the paths from the given source into an impulse
+// response per channel, each path weighted by the channel gains p gives for
+// the direction it arrives from. The paths caught at either ear are referred
+// to the centre of the head and averaged.
+func (g *Game) buildPannedResponse(source int, p panner) [][]float64 {
+	paths := append(
+		g.atHeadCentre(pathsFrom(g.leftPaths, source), g.listener.leftEar),
+		g.atHeadCentre(pathsFrom(g.rightPaths, source), g.listener.rightEar)...)
+	gains := make([][]float64, len(paths))
+	for i, path := range paths {
+		// Half the pressure of each ear, a quarter of its energy
+		paths[i].energy = path.energy.scale(0.25)
+		gains[i] = p.pan(azimuthOf(g.listener.relative(path.direction)))
+	}
+
+	length := impulseResponseLength(paths)
+	responses := make([][]float64, p.channels())
+	for ch := range responses {
+		// Channels no path reaches, such as an LFE channel or Ambisonic
+		// components that vanish in the horizontal plane, are left silent
+		// without rendering them
+		silent := true
+		for i := range paths {
+			silent = silent && math.Abs(gains[i][ch]) < 1e-12
+		}
+		if silent {
+			responses[ch] = make([]float64, length)
+			continue
+		}
+		panned := func(direction Vector) []float64 {
+			return []float64{p.pan(azimuthOf(g.listener.relative(direction)))[ch]}
+		}
+		responses[ch] = renderPaths(paths, panned, length)
+	}
+	return responses
+}
+
+// outputChannels returns the number of channels buildResponses produces.
+func (g *Game) outputChannels() int {
+	if p := g.panner(); p != nil {
+		return p.channels()
+	}
+	return 2
+}
+
+// channelMask returns the WAV channel mask of the output channels.
+func (g *Game) channelMask() uint32 {
+	if g.speakers != nil {
+		return g.speakers.mask
+	}
+	return 0
+}
+
+// pathsFrom returns the paths that start at the given source and go into its
+// impulse response, leaving out those rendered through delay taps.
+func pathsFrom(paths []AudioPath, source int) []AudioPath {
+	var from []AudioPath
+	for _, path := range paths {
+		if path.source == source && !path.doppler {
+			from = append(from, path)
+		}
+	}
+	return from
+}
+
+// earResponse returns the filter applied to sound reaching one ear from a
+// given direction.
+func (g *Game) earResponse(isLeft bool) func(direction Vector) []float64 {
+	if g.hrtf != nil {
+		return func(direction Vector) []float64 {
+			return g.hrtf.response(g.listener.relative(direction), isLeft)
+		}
+	}
+	return func(direction Vector) []float64 {
+		return []float64{calculateILD(g.listener.relative(direction), isLeft)}
+	}
+}
+
+// referToHeadCentre returns the paths that reach the given ear referred to
+// the centre of the head. Without an HRTF the Woodworth delay of the ear is
+// added back on.
+func (g *Game) referToHeadCentre(paths []AudioPath, ear Vector, isLeft bool) []AudioPath {
+	centred := g.atHeadCentre(paths, ear)
+	if g.hrtf == nil {
+		speedOfSound := g.atmosphere.speedOfSound()
+		for i, path := range centred {
+			centred[i].delay = math.Max(0, path.delay+g.listener.woodworthDelay(path.direction, isLeft, speedOfSound))
+		}
+	}
+	return centred
+}
+
+// atHeadCentre returns copies of the paths that reach the given ear, delayed
+// to when they would reach the centre of the head instead.
+func (g *Game) atHeadCentre(paths []AudioPath, ear Vector) []AudioPath {
+	offset := Vector{ear.x - g.listener.position.x, ear.y - g.listener.position.y}
+	speedOfSound := g.atmosphere.speedOfSound()
+	centred := make([]AudioPath, len(paths))
+	for i, path := range paths {
+		centred[i] = path
+		centred[i].delay = math.Max(0, path.delay+dot(offset, path.direction)/speedOfSound)
+	}
+	return centred
+}
+
+// impulseResponseLength returns the number of samples needed to hold every
+// path, including the tail of the interpolation kernel.
+func impulseResponseLength(pathSets ...[]AudioPath) int {
+	maxDelay := 0.0
+	for _, paths := range pathSets {
+		for _, path := range paths {
+			maxDelay = math.Max(maxDelay, path.delay)
+		}
+	}
+	maxDelay = math.Min(maxDelay, maxImpulseResponseSeconds)
+	return int(math.Ceil(maxDelay*sampleRate)) + fractionalDelayHalfWidth + 1
+}
+
+// renderPaths places every path at its fractional delay, filtered by the ear's
+// response to its direction and scaled by the pressure of its bands, the
+// square root of their energy, then filters each band's impulses into its
+// octave and sums them.
+//
+// Each band is the zero-phase lowpass at its upper crossover minus the one at
+// its lower crossover, so the bands of an impulse add back up to it. A
+// crossover is the upper one of one band and the lower one of the next, so by
+// linearity the sum needs only one lowpass per crossover, of the difference
+// between those bands' impulses.
+func renderPaths(paths []AudioPath, ear func(direction Vector) []float64, length int) []float64 {
+	var bandImpulses [numBands][]float64
+	for b := range bandImpulses {
+		bandImpulses[b] = make([]float64, length)
+	}
+
+	var kernel [2 * fractionalDelayHalfWidth]float64
+	var taps []float64
+	for _, path := range paths {
+		position := path.delay * sampleRate
+		first := int(math.Floor(position)) - fractionalDelayHalfWidth + 1
+		fractionalDelayKernel(position-math.Floor(position), kernel[:])
+
+		// The interpolation kernel convolved with the ear's response
+		filter := ear(path.direction)
+		taps = append(taps[:0], make([]float64, len(kernel)+len(filter)-1)...)
+		for i, k := range kernel {
+			for j, f := range filter {
+				taps[i+j] += k * f
+			}
+		}
+
+		for b := range bandImpulses {
+			amplitude := math.Sqrt(path.energy[b])
+			if amplitude == 0 {
+				continue
+			}
+			for k, tap := range taps {
+				if i := first + k; i >= 0 && i < length {
+					bandImpulses[b][i] += amplitude * tap
+				}
+			}
+		}
+	}
+
+	response := append([]float64(nil), bandImpulses[numBands-1]...)
+	for k := 0; k < numBands-1; k++ {
+		difference := bandImpulses[k]
+		for i, next := range bandImpulses[k+1] {
+			difference[i] -= next
+		}
+		for i, sample := range zeroPhaseLowpass(difference, bandFrequencies[k]*math.Sqrt2) {
+			response[i] += sample
+		}
+	}
+	return response
+}
+
+// fractionalDelayKernel fills kernel with a Blackman-windowed sinc that delays
+// by frac samples relative to the kernel's centre tap, at index
+// len(kernel)/2-1.
+func fractionalDelayKernel(frac float64, kernel []float64) {
+	half := len(kernel) / 2
+	for k := range kernel {
+		x := float64(k-half+1) - frac
+		sinc := 1.0
+		if x != 0 {
+			sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+		}
+		phase := (x + float64(half)) / float64(2*half)
+		window := 0.42 - 0.5*math.Cos(2*math.Pi*phase) + 0.08*math.Cos(4*math.Pi*phase)
+		kernel[k] = sinc * window
+	}
+}
+
+// zeroPhaseLowpass runs a second-order Butterworth lowpass forwards and then
+// backwards over signal, which cancels its phase shift.
+func zeroPhaseLowpass(signal []float64, cutoff float64) []float64 {
+	filter := newLowpass(cutoff)
+	out := append([]float64(nil), signal...)
+	filter.run(out, false)
+	filter.reset()
+	filter.run(out, true)
+	return out
+}
+
+// biquad is a second-order IIR filter in transposed direct form II.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+// newLowpass returns a Butterworth lowpass at cutoff Hz.
+func newLowpass(cutoff float64) *biquad {
+	w0 := 2 * math.Pi * cutoff / sampleRate
+	alpha := math.Sin(w0) / math.Sqrt2 // Q = 1/√2
+	cosW0 := math.Cos(w0)
+	a0 := 1 + alpha
+	return &biquad{
+		b0: (1 - cosW0) / 2 / a0,
+		b1: (1 - cosW0) / a0,
+		b2: (1 - cosW0) / 2 / a0,
+		a1: -2 * cosW0 / a0,
+		a2: (1 - alpha) / a0,
+	}
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+func (f *biquad) reset() {
+	f.z1, f.z2 = 0, 0
+}
+
+// run filters samples in place, from the last one to the first if backwards
+// is set. It is process over a whole slice, with the state kept in locals and
+// the decaying tail flushed to zero before it turns subnormal, which would
+// slow each step down a hundredfold.
+func (f *biquad) run(samples []float64, backwards bool) {
+	b0, b1, b2, a1, a2 := f.b0, f.b1, f.b2, f.a1, f.a2
+	z1, z2 := f.z1, f.z2
+	if backwards {
+		for i := len(samples) - 1; i >= 0; i-- {
+			x := samples[i]
+			y := b0*x + z1
+			z1 = flushSubnormal(b1*x - a1*y + z2)
+			z2 = flushSubnormal(b2*x - a2*y)
+			samples[i] = flushSubnormal(y)
+		}
+	} else {
+		for i, x := range samples {
+			y := b0*x + z1
+			z1 = flushSubnormal(b1*x - a1*y + z2)
+			z2 = flushSubnormal(b2*x - a2*y)
+			samples[i] = flushSubnormal(y)
+		}
+	}
+	f.z1, f.z2 = z1, z2
+}
+
+// flushSubnormal returns x, or zero if it is too small to be heard, so that
+// it never reaches the subnormal range.
+func flushSubnormal(x float64) float64 {
+	if math.Abs(x) < 1e-30 {
+		return 0
+	}
+	return x
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRenderPathsSplitsBandsAtCrossovers(t *testing.T) {
+	tests := []struct {
+		name   string
+		energy bands
+	}{
+		{name: "uniform", energy: uniformBands(0.25)},
+		{name: "falling", energy: bands{1, 0.8, 0.5, 0.3, 0.2, 0.1, 0.05}},
+		{name: "single band", energy: bands{0, 0, 0, 1, 0, 0, 0}},
+		{name: "top band only", energy: bands{0, 0, 0, 0, 0, 0, 0.5}},
+	}
+
+	g := &Game{listener: Listener{heading: -math.Pi / 2}}
+	path := AudioPath{delay: 200.3 / sampleRate, direction: Vector{0, -1}}
+	length := impulseResponseLength([]AudioPath{path}) + 400
+	path.energy = uniformBands(1)
+	unit := renderPaths([]AudioPath{path}, g.earResponse(true), length)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path.energy = tt.energy
+			got := renderPaths([]AudioPath{path}, g.earResponse(true), length)
+
+			// Each band's pressure times that octave of the unit response
+			want := make([]float64, length)
+			for b := 0; b < numBands; b++ {
+				for i, sample := range octaveBand(unit, b) {
+					want[i] += math.Sqrt(tt.energy[b]) * sample
+				}
+			}
+			for i := range want {
+				if math.Abs(got[i]-want[i]) > 1e-9 {
+					t.Fatalf("sample %d = %v, want %v", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRenderPathsPlacesFractionalDelay(t *testing.T) {
+	tests := []struct {
+		delaySamples float64
+	}{
+		{1000},
+		{1000.25},
+		{1000.5},
+		{1000.75},
+	}
+
+	for _, tt := range tests {
+		path := AudioPath{
+			delay:     tt.delaySamples / sampleRate,
+			energy:    uniformBands(0.25),
+			direction: Vector{0, -1}, // straight ahead, the same level at both ears
+		}
+		length := impulseResponseLength([]AudioPath{path})
+		g := &Game{listener: Listener{heading: -math.Pi / 2}}
+		response := renderPaths([]AudioPath{path}, g.earResponse(true), length)
+		gain := 0.5 * calculateILD(path.direction, true)
+
+		// At low frequencies the response has the path's gain and its
+		// group delay is the delay of the path
+		var sum, moment float64
+		for i, sample := range response {
+			sum += sample
+			moment += float64(i) * sample
+		}
+
+		if math.Abs(sum-gain) > 1e-3*gain {
+			t.Errorf("delay %v samples: DC gain %v, want %v", tt.delaySamples, sum, gain)
+		}
+		if delay := moment / sum; math.Abs(delay-tt.delaySamples) > 0.01 {
+			t.Errorf("delay %v samples: response delayed by %v", tt.delaySamples, delay)
+		}
+	}
+}
+
+// octaveBand extracts band b from signal with zero-phase filters. Each band is
+// the difference between the lowpasses at its upper and lower crossover, so
+// the bands of one signal add back up to exactly that signal.
+func octaveBand(signal []float64, b int) []float64 {
+	upper := signal
+	if b < numBands-1 {
+		upper = zeroPhaseLowpass(signal, bandFrequencies[b]*math.Sqrt2)
+	}
+	if b == 0 {
+		return upper
+	}
+
+	lower := zeroPhaseLowpass(signal, bandFrequencies[b-1]*math.Sqrt2)
+	band := make([]float64, len(signal))
+	for i := range band {
+		band[i] = upper[i] - lower[i]
+	}
+	return band
+}
@@ -0,0 +1,158 @@
+package main
+
+import "math"
+
+const (
+	// Oversampling of the true-peak detector, as in ITU-R BS.1770 Annex 2.
+	truePeakOversampling = 4
+	// Taps per phase of the detector's interpolation filter.
+	truePeakTaps = 16
+	// How far ahead the limiter looks, and so how long it takes to pull the
+	// gain down before a peak, in seconds.
+	limiterLookahead = 0.002
+	// Time constant of the limiter's recovery after a peak, in seconds.
+	limiterRelease = 0.1
+)
+
+// truePeakDetector finds the peaks of a multichannel signal between its
+// samples as well as on them, by interpolating truePeakOversampling points
+// per sample. Peaks come out truePeakTaps/2 samples after the sample they
+// belong to has gone in.
+type truePeakDetector struct {
+	phases  [][]float64 // interpolation filters for the points after a sample, oldest input first
+	history [][]float64 // last truePeakTaps samples per channel, oldest first
+}
+
+func newTruePeakDetector(channels int) *truePeakDetector {
+	d := &truePeakDetector{history: make([][]float64, channels)}
+	for ch := range d.history {
+		d.history[ch] = make([]float64, truePeakTaps)
+	}
+	// The interpolated points lie between the two middle taps
+	for p := 1; p < truePeakOversampling; p++ {
+		filter := make([]float64, truePeakTaps)
+		sum := 0.0
+		for j := range filter {
+			x := float64(p)/truePeakOversampling + float64(truePeakTaps/2-1-j)
+			filter[j] = sinc(x) * kaiser(x/(truePeakTaps/2), 8)
+			sum += filter[j]
+		}
+		for j := range filter {
+			filter[j] /= sum
+		}
+		d.phases = append(d.phases, filter)
+	}
+	return d
+}
+
+// push takes the next frame, a sample per channel, and returns the largest
+// magnitude on or after the sample truePeakTaps/2 frames earlier and before
+// the one after it, across all channels.
+func (d *truePeakDetector) push(frame []float64) float64 {
+	peak := 0.0
+	for ch, history := range d.history {
+		copy(history, history[1:])
+		history[len(history)-1] = frame[ch]
+		peak = math.Max(peak, math.Abs(history[truePeakTaps/2-1]))
+		for _, filter := range d.phases {
+			y := 0.0
+			for j, h := range filter {
+				y += h * history[j]
+			}
+			peak = math.Max(peak, math.Abs(y))
+		}
+	}
+	return peak
+}
+
+// truePeakLimiter keeps the true peak of a multichannel signal at or below a
+// ceiling by turning all channels down together. It works out the gain each
+// sample needs from its true peak, holds the lowest gain needed over the
+// look-ahead, and smooths it with a moving average as long as the
+// look-ahead, so the gain has come down fully by the time the peak plays.
+// Afterwards the gain recovers exponentially. The audio is delayed by the
+// look-ahead and the detector's delay.
+type truePeakLimiter struct {
+	ceiling   float64 // linear
+	lookahead int
+	recovery  float64 // share of the way back to unity gain recovered per sample
+	detector  *truePeakDetector
+	delayed   [][]float64 // ring of input per channel
+	needed    []float64   // ring of the gain each sample needs
+	held      []float64   // ring of the needed gain after the hold and recovery
+	position  int
+	frame     []float64
+	lowest    float64 // lowest gain applied since the last call to takeLowestGain
+}
+
+// newTruePeakLimiter returns a limiter with a ceiling in dBTP.
+func newTruePeakLimiter(channels int, ceiling float64) *truePeakLimiter {
+	l := &truePeakLimiter{
+		ceiling:   math.Pow(10, ceiling/20),
+		lookahead: int(math.Round(limiterLookahead * sampleRate)),
+		recovery:  1 - math.Exp(-1/(limiterRelease*sampleRate)),
+		detector:  newTruePeakDetector(channels),
+		delayed:   make([][]float64, channels),
+		frame:     make([]float64, channels),
+		lowest:    1,
+	}
+	for ch := range l.delayed {
+		l.delayed[ch] = make([]float64, l.delay()+1)
+	}
+	l.needed = make([]float64, l.lookahead+1)
+	l.held = make([]float64, l.lookahead+1)
+	for i := range l.needed {
+		l.needed[i], l.held[i] = 1, 1
+	}
+	return l
+}
+
+// delay returns how many samples the limiter delays its input by.
+func (l *truePeakLimiter) delay() int {
+	return truePeakTaps/2 + l.lookahead
+}
+
+// process limits the channels in place.
+func (l *truePeakLimiter) process(channels [][]float64) {
+	for i := range channels[0] {
+		for ch, channel := range channels {
+			l.frame[ch] = channel[i]
+			l.delayed[ch][l.position%len(l.delayed[ch])] = channel[i]
+		}
+		peak := l.detector.push(l.frame)
+		slot := l.position % len(l.needed)
+		l.needed[slot] = 1
+		if peak > l.ceiling {
+			l.needed[slot] = l.ceiling / peak
+		}
+
+		// The lowest gain needed from here to the end of the look-ahead,
+		// recovering from the last held gain no faster than the release
+		previous := l.held[(l.position+len(l.held)-1)%len(l.held)]
+		hold := math.Min(previous+(1-previous)*l.recovery, minimum(l.needed))
+		l.held[slot] = hold
+		gain := mean(l.held)
+		l.lowest = math.Min(l.lowest, gain)
+
+		out := (l.position + 1) % len(l.delayed[0])
+		for ch, channel := range channels {
+			channel[i] = gain * l.delayed[ch][out]
+		}
+		l.position++
+	}
+}
+
+// takeLowestGain returns the lowest gain applied since it was last called.
+func (l *truePeakLimiter) takeLowestGain() float64 {
+	lowest := l.lowest
+	l.lowest = 1
+	return lowest
+}
+
+func minimum(values []float64) float64 {
+	low := math.Inf(1)
+	for _, v := range values {
+		low = math.Min(low, v)
+	}
+	return low
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestLimiterKeepsTruePeakUnderCeiling(t *testing.T) {
+	tests := []struct {
+		name      string
+		frequency float64
+		phase     float64
+		amplitude float64
+	}{
+		// A quarter of the sample rate at 45° puts every sample at 0.707 of
+		// a peak that falls between them
+		{"inter-sample peaks", sampleRate / 4, math.Pi / 4, 2},
+		{"997 Hz", 997, 0, 4},
+		{"just over", 5000, 0.3, 1},
+	}
+	for _, tt := range tests {
+		in := make([]float64, sampleRate)
+		for i := range in {
+			in[i] = tt.amplitude * math.Sin(2*math.Pi*tt.frequency*float64(i)/sampleRate+tt.phase)
+		}
+		out := append([]float64(nil), in...)
+		limiter := newTruePeakLimiter(1, -1)
+		for start := 0; start < len(out); start += convolutionBlockSize {
+			limiter.process([][]float64{out[start:min(len(out), start+convolutionBlockSize)]})
+		}
+
+		// Measure independently of the limiter's own detector, by
+		// resampling to four times the rate
+		upsampled := resample([][]float64{out}, sampleRate, 4*sampleRate)[0]
+		peak := 0.0
+		for _, x := range upsampled[4*sampleRate/10 : 4*sampleRate*9/10] {
+			peak = math.Max(peak, math.Abs(x))
+		}
+		if got := decibels(peak); got > -1+0.2 {
+			t.Errorf("%s: true peak %.2f dBTP, want at most -1", tt.name, got)
+		}
+		if got := decibels(peak); got < -1-0.5 {
+			t.Errorf("%s: true peak %.2f dBTP, limited well under the ceiling of -1", tt.name, got)
+		}
+	}
+}
+
+func TestLimiterPassesQuietSignalsThroughDelayed(t *testing.T) {
+	in := sineAt(440, sampleRate)
+	for i := range in {
+		in[i] *= 0.5
+	}
+	out := append([]float64(nil), in...)
+	limiter := newTruePeakLimiter(1, -1)
+	limiter.process([][]float64{out})
+
+	delay := limiter.delay()
+	for i := 0; i < delay; i++ {
+		if out[i] != 0 {
+			t.Fatalf("sample %d = %v before the delay of %d, want 0", i, out[i], delay)
+		}
+	}
+	for i := delay; i < len(out); i++ {
+		if math.Abs(out[i]-in[i-delay]) > 1e-12 {
+			t.Fatalf("sample %d = %v, want %v from %d samples earlier", i, out[i], in[i-delay], delay)
+		}
+	}
+	if gain := limiter.takeLowestGain(); gain != 1 {
+		t.Errorf("lowest gain = %v, want 1", gain)
+	}
+}
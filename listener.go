@@ -0,0 +1,42 @@
+package main
+
+import "math"
+
+// facing returns the unit vector the listener faces.
+func (l Listener) facing() Vector {
+	return Vector{math.Cos(l.heading), math.Sin(l.heading)}
+}
+
+// placeEars puts the ears either side of the head, square to the heading.
+func (l *Listener) placeEars() {
+	facing := l.facing()
+	left := Vector{facing.y, -facing.x}
+	l.leftEar = Vector{l.position.x + l.headRadius*left.x, l.position.y + l.headRadius*left.y}
+	l.rightEar = Vector{l.position.x - l.headRadius*left.x, l.position.y - l.headRadius*left.y}
+}
+
+// relative returns a direction in the scene as the listener sees it: rotated
+// so that straight ahead is -y and the left ear is towards -x.
+func (l Listener) relative(direction Vector) Vector {
+	angle := -math.Pi/2 - l.heading
+	sin, cos := math.Sincos(angle)
+	return Vector{direction.x*cos - direction.y*sin, direction.x*sin + direction.y*cos}
+}
+
+// woodworthDelay returns how much later than at the centre of the head a
+// plane wave arriving from direction reaches one ear of a spherical head. The
+// near ear hears it (r/c)·sin θ early, where θ is the angle off the median
+// plane, while the wave has to bend around the head to the far ear and
+// arrives (r/c)·θ late, giving Woodworth's ITD of (r/c)(θ + sin θ).
+func (l Listener) woodworthDelay(direction Vector, isLeft bool, speedOfSound float64) float64 {
+	lateral := -l.relative(direction).x // towards the left ear
+	if !isLeft {
+		lateral = -lateral
+	}
+	lateral = math.Max(-1, math.Min(1, lateral))
+
+	if lateral >= 0 {
+		return -l.headRadius / speedOfSound * lateral
+	}
+	return l.headRadius / speedOfSound * math.Asin(-lateral)
+}
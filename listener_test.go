@@ -0,0 +1,65 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func near(a, b Vector) bool {
+	return math.Abs(a.x-b.x) < 1e-12 && math.Abs(a.y-b.y) < 1e-12
+}
+
+func TestListenerEarsFollowHeading(t *testing.T) {
+	tests := []struct {
+		heading             float64
+		wantLeft, wantRight Vector
+		scene, wantRelative Vector
+	}{
+		// Facing up the screen, as in the default scene
+		{-math.Pi / 2, Vector{0.9, 2}, Vector{1.1, 2}, Vector{-1, 0}, Vector{-1, 0}},
+		// Facing +x, so the left ear is towards -y
+		{0, Vector{1, 1.9}, Vector{1, 2.1}, Vector{1, 0}, Vector{0, -1}},
+		// Facing down the screen, so sound from above is behind
+		{math.Pi / 2, Vector{1.1, 2}, Vector{0.9, 2}, Vector{0, -1}, Vector{0, 1}},
+	}
+
+	for _, tt := range tests {
+		l := Listener{position: Vector{1, 2}, heading: tt.heading, headRadius: 0.1}
+		l.placeEars()
+		if !near(l.leftEar, tt.wantLeft) || !near(l.rightEar, tt.wantRight) {
+			t.Errorf("heading %v: ears at %v and %v, want %v and %v", tt.heading, l.leftEar, l.rightEar, tt.wantLeft, tt.wantRight)
+		}
+		if got := l.relative(tt.scene); !near(got, tt.wantRelative) {
+			t.Errorf("heading %v: relative(%v) = %v, want %v", tt.heading, tt.scene, got, tt.wantRelative)
+		}
+	}
+}
+
+func TestWoodworthDelay(t *testing.T) {
+	const c = 343.0
+	l := Listener{heading: -math.Pi / 2, headRadius: 0.0875}
+
+	// Straight ahead both ears hear it with the centre of the head
+	for _, isLeft := range []bool{true, false} {
+		if d := l.woodworthDelay(Vector{0, -1}, isLeft, c); math.Abs(d) > 1e-15 {
+			t.Errorf("delay ahead for left=%v is %v, want 0", isLeft, d)
+		}
+	}
+
+	tests := []struct {
+		lateral float64 // angle off the median plane towards the left
+	}{
+		{math.Pi / 2},
+		{math.Pi / 6},
+		{-math.Pi / 4},
+	}
+	for _, tt := range tests {
+		direction := Vector{-math.Sin(tt.lateral), -math.Cos(tt.lateral)}
+		itd := l.woodworthDelay(direction, false, c) - l.woodworthDelay(direction, true, c)
+		theta := math.Abs(tt.lateral)
+		want := math.Copysign(l.headRadius/c*(theta+math.Sin(theta)), tt.lateral)
+		if math.Abs(itd-want) > 1e-12 {
+			t.Errorf("ITD at %v rad = %v, want %v", tt.lateral, itd, want)
+		}
+	}
+}
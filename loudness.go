@@ -0,0 +1,196 @@
+package main
+
+import "math"
+
+const (
+	// Length of the segments loudness is gathered in, in seconds. Momentary
+	// loudness spans 4 of them, short-term loudness 30, and the gating
+	// blocks of integrated loudness are 4 segments long and start every
+	// segment.
+	loudnessSegment = 0.1
+	// Blocks quieter than this in LUFS are left out of integrated loudness.
+	absoluteGate = -70.0
+	// Blocks this many LU quieter than the loudness of the blocks above the
+	// absolute gate are left out too.
+	relativeGate = -10.0
+	// The gating blocks are counted in a histogram of bins this many LU wide,
+	// from the absolute gate up to 100 LU above it.
+	loudnessBinWidth = 0.1
+	loudnessBins     = 1000
+	// Segments kept for short-term loudness, the longest span measured.
+	recentSegments = 30
+)
+
+// kWeighting returns the two filters of the K-weighting of ITU-R BS.1770 at
+// the given sample rate: a high shelf for the acoustic effect of the head,
+// then a high-pass. The standard gives coefficients for 48 kHz only; these
+// come from the analogue prototypes behind them, as in libebur128.
+func kWeighting(rate float64) [2]biquad {
+	k := math.Tan(math.Pi * 1681.974450955533 / rate)
+	q := 0.7071752369554196
+	vh := math.Pow(10, 3.999843853973347/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	k = math.Tan(math.Pi * 38.13547087602444 / rate)
+	q = 0.5003270373238773
+	a0 = 1 + k/q + k*k
+	highPass := biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return [2]biquad{shelf, highPass}
+}
+
+// loudnessMeter measures momentary, short-term and integrated loudness by
+// ITU-R BS.1770 and EBU R128, in LUFS. Each channel is K-weighted and its
+// energy counts with its weight: 1 for front channels, 1.41 for surround
+// channels and 0 for channels left out, such as an LFE channel.
+//
+// As in libebur128, the gating blocks above the absolute gate are kept in a
+// histogram by loudness rather than one by one, so a meter takes the same
+// memory and time however long it runs. Each bin sums the energy of its
+// blocks, which keeps integrated loudness exact but for blocks in the same
+// bin as the relative gate.
+type loudnessMeter struct {
+	weights  []float64
+	filters  [][2]biquad
+	length   int                     // samples per segment
+	energy   float64                 // weighted energy of the segment being gathered
+	count    int                     // samples in it so far
+	recent   [recentSegments]float64 // ring of the mean weighted energy of the last segments
+	segments int                     // segments so far
+	binned   [loudnessBins]float64   // summed energy of the gating blocks per bin
+	blocks   [loudnessBins]int       // number of gating blocks per bin
+	gated    float64                 // integrated loudness as of gatedAt segments
+	gatedAt  int
+}
+
+func newLoudnessMeter(weights []float64, rate float64) *loudnessMeter {
+	m := &loudnessMeter{
+		weights: weights,
+		filters: make([][2]biquad, len(weights)),
+		length:  int(loudnessSegment * rate),
+	}
+	for ch := range m.filters {
+		m.filters[ch] = kWeighting(rate)
+	}
+	return m
+}
+
+// process measures the next samples, one slice per channel.
+func (m *loudnessMeter) process(channels [][]float64) {
+	for i := range channels[0] {
+		for ch, channel := range channels {
+			if m.weights[ch] == 0 {
+				continue
+			}
+			filters := &m.filters[ch]
+			y := filters[1].process(filters[0].process(channel[i]))
+			m.energy += m.weights[ch] * y * y
+		}
+		if m.count++; m.count == m.length {
+			m.recent[m.segments%recentSegments] = m.energy / float64(m.length)
+			m.segments++
+			m.energy, m.count = 0, 0
+			m.addBlock()
+		}
+	}
+}
+
+// addBlock counts the gating block that ends with the newest segment.
+func (m *loudnessMeter) addBlock() {
+	if m.segments < 4 {
+		return
+	}
+	z := m.mean(4)
+	if loudness := loudnessOf(z); loudness > absoluteGate {
+		bin := min(loudnessBins-1, int((loudness-absoluteGate)/loudnessBinWidth))
+		m.binned[bin] += z
+		m.blocks[bin]++
+	}
+}
+
+// mean returns the mean weighted energy of the last n segments.
+func (m *loudnessMeter) mean(n int) float64 {
+	sum := 0.0
+	for i := m.segments - n; i < m.segments; i++ {
+		sum += m.recent[i%recentSegments]
+	}
+	return sum / float64(n)
+}
+
+// over returns the loudness of the last n segments, or -Inf until there
+// have been that many.
+func (m *loudnessMeter) over(n int) float64 {
+	if m.segments < n {
+		return math.Inf(-1)
+	}
+	return loudnessOf(m.mean(n))
+}
+
+// momentary returns the loudness of the last 400 ms.
+func (m *loudnessMeter) momentary() float64 {
+	return m.over(4)
+}
+
+// shortTerm returns the loudness of the last 3 s.
+func (m *loudnessMeter) shortTerm() float64 {
+	return m.over(30)
+}
+
+// integrated returns the gated loudness of everything measured so far, or
+// -Inf if all of it is below the absolute gate.
+func (m *loudnessMeter) integrated() float64 {
+	if m.gatedAt == m.segments && m.gatedAt > 0 {
+		return m.gated
+	}
+	m.gated, m.gatedAt = m.gate(), m.segments
+	return m.gated
+}
+
+// gate works out the integrated loudness from the histogram of blocks. The
+// bin the relative gate falls in counts in full.
+func (m *loudnessMeter) gate() float64 {
+	sum, count := m.sumFrom(0)
+	if count == 0 {
+		return math.Inf(-1)
+	}
+	gate := loudnessOf(sum/float64(count)) + relativeGate
+	sum, count = m.sumFrom(max(0, int((gate-absoluteGate)/loudnessBinWidth)))
+	return loudnessOf(sum / float64(count))
+}
+
+// sumFrom returns the summed energy and the number of the gating blocks from
+// the given bin up.
+func (m *loudnessMeter) sumFrom(bin int) (float64, int) {
+	sum, count := 0.0, 0
+	for b := bin; b < loudnessBins; b++ {
+		sum += m.binned[b]
+		count += m.blocks[b]
+	}
+	return sum, count
+}
+
+// loudnessOf returns the loudness in LUFS of a mean weighted energy.
+func loudnessOf(energy float64) float64 {
+	return -0.691 + 10*math.Log10(energy)
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// toneAt returns seconds of a stereo sine at frequency Hz with its peaks at
+// level dBFS.
+func toneAt(frequency, level, seconds float64) [][]float64 {
+	amplitude := math.Pow(10, level/20)
+	tone := make([]float64, int(seconds*sampleRate))
+	for i := range tone {
+		tone[i] = amplitude * math.Sin(2*math.Pi*frequency*float64(i)/sampleRate)
+	}
+	return [][]float64{tone, append([]float64(nil), tone...)}
+}
+
+func TestLoudnessOfSine(t *testing.T) {
+	// EBU Tech 3341: a 1 kHz sine at -23 dBFS in both stereo channels reads
+	// -23 LUFS
+	tests := []struct {
+		level, want float64
+	}{
+		{-23, -23},
+		{-33, -33},
+		{-3, -3},
+	}
+	for _, tt := range tests {
+		meter := newLoudnessMeter([]float64{1, 1}, sampleRate)
+		meter.process(toneAt(1000, tt.level, 20))
+		for name, got := range map[string]float64{
+			"momentary":  meter.momentary(),
+			"short-term": meter.shortTerm(),
+			"integrated": meter.integrated(),
+		} {
+			if math.Abs(got-tt.want) > 0.1 {
+				t.Errorf("%v dBFS: %s loudness = %.2f LUFS, want %v", tt.level, name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestLoudnessGating(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts [][][]float64
+	}{
+		{"silence", [][][]float64{toneAt(1000, -23, 10), toneAt(1000, math.Inf(-1), 10)}},
+		{"below the absolute gate", [][][]float64{toneAt(1000, -80, 10), toneAt(1000, -23, 10)}},
+		{"below the relative gate", [][][]float64{toneAt(1000, -23, 10), toneAt(1000, -43, 10)}},
+	}
+	for _, tt := range tests {
+		meter := newLoudnessMeter([]float64{1, 1}, sampleRate)
+		for _, part := range tt.parts {
+			meter.process(part)
+		}
+		if got := meter.integrated(); math.Abs(got+23) > 0.1 {
+			t.Errorf("%s: integrated loudness = %.2f LUFS, want -23", tt.name, got)
+		}
+	}
+
+	meter := newLoudnessMeter([]float64{1, 1}, sampleRate)
+	meter.process(toneAt(1000, math.Inf(-1), 5))
+	if got := meter.integrated(); !math.IsInf(got, -1) {
+		t.Errorf("integrated loudness of silence = %v, want -Inf", got)
+	}
+}
+
+func TestLoudnessHistogramMatchesExactGating(t *testing.T) {
+	// A tone that changes level every second, through both gates
+	rng := rand.New(rand.NewSource(4))
+	meter := newLoudnessMeter([]float64{1, 1}, sampleRate)
+	var segments []float64
+	for second := 0; second < 60; second++ {
+		tone := toneAt(1000, -60+55*rng.Float64(), 1)
+		for start := 0; start < sampleRate; start += sampleRate / 10 {
+			meter.process([][]float64{tone[0][start : start+sampleRate/10], tone[1][start : start+sampleRate/10]})
+			segments = append(segments, math.Pow(10, (meter.over(1)+0.691)/10))
+		}
+	}
+
+	var blocks []float64
+	for i := 4; i <= len(segments); i++ {
+		if z := mean(segments[i-4 : i]); loudnessOf(z) > absoluteGate {
+			blocks = append(blocks, z)
+		}
+	}
+	gate := loudnessOf(mean(blocks)) + relativeGate
+	var gated []float64
+	for _, z := range blocks {
+		if loudnessOf(z) > gate {
+			gated = append(gated, z)
+		}
+	}
+	want := loudnessOf(mean(gated))
+	if got := meter.integrated(); math.Abs(got-want) > 0.05 {
+		t.Errorf("integrated loudness = %.3f LUFS, want %.3f", got, want)
+	}
+}
@@ -1,335 +1,641 @@
-package main
-
-import (
-	"fmt"
-	"image/color"
-	"log"
-	"math"
-	"sync"
-
-	"github.com/hajimehoshi/ebiten/v2"
-	"github.com/hajimehoshi/ebiten/v2/inpututil"
-	"github.com/hajimehoshi/ebiten/v2/vector"
-	"github.com/hajimehoshi/oto/v2"
-)
-
-const (
-	screenWidth        = 1920
-	screenHeight       = 1080
-	numRays            = 360
-	maxBounces         = 2
-	speedOfSound       = 343.0 // m/s
-	sineFreq           = 200   // Frequency of sine wave in Hz
-	sampleRate         = 44100 // Sample rate for audio
-	proximityThreshold = 5.0
-	volume             = 1000
-)
-
-func (g *Game) Update() error {
-	g.frame++
-
-	// Get current mouse position
-	x, y := ebiten.CursorPosition()
-	mousePosition := Vector{float64(x), float64(y)}
-
-	// Check mouse button state
-	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
-		// If the mouse button was just pressed, set the listener position
-		g.listener.position = mousePosition
-		g.isDragging = true // Start dragging
-	}
-
-	if inpututil.IsMouseButtonJustReleased(ebiten.MouseButtonLeft) {
-		// If the mouse button was released, stop dragging
-		g.isDragging = false
-	}
-
-	if g.isDragging {
-		// Update the listener's position to follow the mouse while dragging
-		g.listener.position = mousePosition
-	}
-
-	g.rays = make([]Ray, numRays)
-	g.leftPaths = make([]AudioPath, 0)
-	g.rightPaths = make([]AudioPath, 0)
-	g.rayPathPoints = make([][]RayPathPoint, numRays)
-	initialIntensity := 1.0
-
-	for i := 0; i < numRays; i++ {
-		angle := float64(i) * 2 * math.Pi / float64(numRays)
-		direction := Vector{math.Cos(angle), math.Sin(angle)}
-		g.rays[i] = Ray{g.listener.position, direction}
-		g.rayPathPoints[i] = []RayPathPoint{{g.listener.position, initialIntensity}}
-		g.traceRay(g.rays[i], initialIntensity, maxBounces, i)
-	}
-
-	// Generate audio data and append it to the ring buffer
-	g.generateAudio()
-
-	return nil
-}
-
-func calculateILD(direction Vector, isLeft bool) float64 {
-	const minAttenuation = 0.3
-
-	angle := math.Atan2(direction.y, direction.x) - math.Pi/2
-	shadowEffect := 0.5 * (1.0 + math.Abs(math.Sin(angle)))
-	if isLeft {
-		shadowEffect = 1.0 - shadowEffect
-	}
-	return minAttenuation + (1.0-minAttenuation)*shadowEffect
-}
-
-func (g *Game) generateAudio() {
-	var wg sync.WaitGroup
-
-	leftChannel := make(chan float64, len(g.buffer)/4)
-	rightChannel := make(chan float64, len(g.buffer)/4)
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		for i, path := range g.leftPaths {
-			g.leftPaths[i].ild = calculateILD(path.direction, true)
-		}
-
-		for i := 0; i < len(g.buffer); i += 4 {
-			var sampleLeft float64 = 0
-			currentTime := float64(g.totalSamples+int(i/4)) / float64(sampleRate)
-
-			for _, path := range g.leftPaths {
-				adjustedAmplitude := path.amplitude * path.ild
-				baseSignal := 2 * math.Pi * path.source.frequency * (currentTime - path.delay)
-				sampleLeft += adjustedAmplitude * volume * math.Sin(baseSignal)
-			}
-
-			leftChannel <- sampleLeft
-		}
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		for i, path := range g.rightPaths {
-			g.rightPaths[i].ild = calculateILD(path.direction, false)
-		}
-
-		for i := 0; i < len(g.buffer); i += 4 {
-			var sampleRight float64 = 0
-			currentTime := float64(g.totalSamples+int(i/4)) / float64(sampleRate)
-
-			for _, path := range g.rightPaths {
-				adjustedAmplitude := path.amplitude * path.ild
-				baseSignal := 2 * math.Pi * path.source.frequency * (currentTime - path.delay)
-				sampleRight += adjustedAmplitude * volume * math.Sin(baseSignal)
-			}
-
-			rightChannel <- sampleRight
-		}
-	}()
-
-	wg.Wait()
-	close(leftChannel)
-	close(rightChannel)
-
-	for i := 0; i < len(g.buffer); i += 4 {
-		sampleLeft := int16(<-leftChannel)
-		sampleRight := int16(<-rightChannel)
-		g.buffer[i] = byte(sampleLeft & 0xFF)
-		g.buffer[i+1] = byte((sampleLeft >> 8) & 0xFF)
-		g.buffer[i+2] = byte(sampleRight & 0xFF)
-		g.buffer[i+3] = byte((sampleRight >> 8) & 0xFF)
-		g.totalSamples++
-	}
-}
-
-// Draw implements ebiten.Game's Draw function. It draws the game's walls, the
-// audio source and listener, and the intersection points of the rays with the
-// walls.
-func (g *Game) Draw(screen *ebiten.Image) {
-	screen.Fill(color.Black)
-
-	for _, path := range g.rayPathPoints {
-		for i := 0; i < len(path)-1; i++ {
-			// Get start intensity
-			startIntensity := path[i].intensity
-
-			// Get start and end positions
-			startX := float32(path[i].position.x)
-			startY := float32(path[i].position.y)
-			endX := float32(path[i+1].position.x)
-			endY := float32(path[i+1].position.y)
-
-			// Calculate the total segment length
-			totalLength := float32(math.Sqrt(float64(
-				(endX-startX)*(endX-startX) +
-					(endY-startY)*(endY-startY))))
-
-			// Draw multiple small segments to create gradient
-			numSegments := 50 // Increase for smoother gradient
-			for j := 0; j < numSegments; j++ {
-				progress := float64(j) / float64(numSegments)
-
-				// Interpolate positions
-				x1 := startX + float32(progress)*(endX-startX)
-				y1 := startY + float32(progress)*(endY-startY)
-				x2 := startX + float32(progress+1.0/float64(numSegments))*(endX-startX)
-				y2 := startY + float32(progress+1.0/float64(numSegments))*(endY-startY)
-
-				// Calculate distance from start for this segment
-				distanceFromStart := totalLength * float32(progress)
-
-				// Calculate intensity using inverse square law
-				// Add a small offset to prevent division by zero
-				segmentIntensity := startIntensity / (1 + float64(distanceFromStart*distanceFromStart)/10000)
-
-				// Calculate color for this segment
-				r := uint8(150 * segmentIntensity)
-				g := uint8(0)
-				b := uint8(10 - 10*segmentIntensity)
-				a := uint8(20 * segmentIntensity)
-
-				rayColor := color.RGBA{r, g, b, a}
-
-				// Draw the small segment
-				vector.StrokeLine(screen, x1, y1, x2, y2, 5, rayColor, true)
-			}
-		}
-	}
-	// Draw walls
-	for _, wall := range g.walls {
-		vector.StrokeLine(screen, float32(wall.start.x), float32(wall.start.y), float32(wall.end.x), float32(wall.end.y), 1, color.RGBA{255, 255, 255, 255}, true)
-	}
-	// Draw audio source
-	vector.DrawFilledCircle(screen, float32(g.audioSource.position.x), float32(g.audioSource.position.y), 5, color.RGBA{255, 255, 255, 255}, true)
-
-	// Draw listener
-	vector.DrawFilledCircle(screen, float32(g.listener.position.x), float32(g.listener.position.y), 5, color.RGBA{0, 0, 255, 100}, true)
-
-}
-
-func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
-	return screenWidth, screenHeight
-}
-
-// main initializes the game and starts the game loop. It creates an oto audio
-// context, sets up the game state (walls, audio source, listener, and audio
-// buffer), and creates an oto player for the game. It then sets up the Ebiten
-// window and starts the game loop with ebiten.RunGame. If there's an error, it
-// logs the error and exits.
-func main() {
-	otoCtx, readyChan, err := oto.NewContext(sampleRate, 2, 2)
-	if err != nil {
-		log.Fatal(err)
-	}
-	<-readyChan
-
-	game := &Game{
-		walls: []Wall{
-			{Vector{240, 180}, Vector{1680, 180}, WallProperties{absorption: 0.2, transparency: 0.2, transmissionRoughness: 0.5, roughness: 0.5}},
-			{Vector{1680, 180}, Vector{1680, 900}, WallProperties{absorption: 0.2, transparency: 0.2, transmissionRoughness: 0.5, roughness: 0.5}},
-			{Vector{1680, 900}, Vector{240, 900}, WallProperties{absorption: 0.2, transparency: 0.2, transmissionRoughness: 0.5, roughness: 0.5}},
-			{Vector{240, 900}, Vector{240, 180}, WallProperties{absorption: 0.2, transparency: 0.2, transmissionRoughness: 0.5, roughness: 0.5}},
-			{Vector{400, 750}, Vector{400, 320}, WallProperties{absorption: 0.2, transparency: 0.5, transmissionRoughness: 0.5, roughness: 0.5}},
-		},
-		audioSource:  AudioSource{Vector{1000, 535}, sineFreq, 0.5},
-		listener:     Listener{Vector{800, 535}, Vector{795, 535}, Vector{805, 535}},
-		audioContext: otoCtx,
-		buffer:       make([]byte, 176400),
-		totalSamples: 0,
-	}
-	game.getWallEdges()
-	fmt.Println(game.wallEdges)
-
-	game.player = otoCtx.NewPlayer(game)
-
-	ebiten.SetWindowSize(screenWidth, screenHeight)
-	ebiten.SetWindowTitle("2D Audio Ray Tracing")
-	ebiten.SetTPS(2)
-
-	game.player.Play()
-
-	if err := ebiten.RunGame(game); err != nil {
-		log.Fatal(err)
-	}
-}
-
-// Returns all edges/corners for diffraction calculations
-func (g *Game) getWallEdges() {
-	edges := make([]WallEdge, 0)
-	pointMap := make(map[Vector]bool)
-
-	// Iterate through each wall
-	for i, wall1 := range g.walls {
-		// Calculate the wall's direction and normal
-		wallDir := Vector{
-			wall1.end.x - wall1.start.x,
-			wall1.end.y - wall1.start.y,
-		}.normalize()
-		wallNormal := Vector{-wallDir.y, wallDir.x}
-
-		// Add wall endpoints
-		for _, point := range []Vector{wall1.start, wall1.end} {
-			if !pointMap[point] {
-				edges = append(edges, WallEdge{
-					position: point,
-					normal1:  wallNormal,
-					isCorner: false,
-				})
-				pointMap[point] = true
-			}
-		}
-
-		// Check if the end of wall1 is a corner shared with another wall
-		for j, wall2 := range g.walls {
-			if i == j {
-				continue // Skip if it's the same wall
-			}
-			wall2Dir := Vector{
-				wall2.end.x - wall2.start.x,
-				wall2.end.y - wall2.start.y,
-			}.normalize()
-			wall2Normal := Vector{-wall2Dir.y, wall2Dir.x}
-
-			if wall1.start.x == wall2.end.x && wall1.start.y == wall2.end.y {
-				// Mark the point as a corner
-				for k, edge := range edges {
-					if edge.position == wall1.start {
-						edges[k].isCorner = true
-						edges[k].normal2 = wall2Normal
-						break
-					}
-				}
-			}
-			if wall1.end.x == wall2.start.x && wall1.end.y == wall2.start.y {
-				// Mark the point as a corner
-				for k, edge := range edges {
-					if edge.position == wall1.end {
-						edges[k].isCorner = true
-						edges[k].normal2 = wall2Normal
-						break
-					}
-				}
-			}
-		}
-	}
-
-	g.wallEdges = edges
-}
-
-// Read implements the io.Reader interface for the Game type. It fills the given buffer
-// with audio samples from the ring buffer. If the ring buffer is empty, it will block
-// until more audio data is generated.
-func (g *Game) Read(buf []byte) (int, error) {
-	// Ensure there are enough samples available in the buffer
-	if len(g.buffer) < len(buf) {
-		copy(buf, g.buffer)
-	}
-
-	// Copy generated audio data into the output buffer
-	copy(buf, g.buffer[:len(buf)])
-
-	return len(buf), nil
-}
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/ebiten/v2"
+	"github.com/hajimehoshi/ebiten/v2/ebitenutil"
+	"github.com/hajimehoshi/ebiten/v2/inpututil"
+	"github.com/hajimehoshi/ebiten/v2/vector"
+	"github.com/hajimehoshi/oto/v2"
+)
+
+const (
+	screenWidth        = 1920
+	screenHeight       = 1080
+	numRays            = 360
+	maxReflectionOrder = 100
+	imageSourceOrder   = 3
+	sineFreq           = 200    // Frequency of sine wave in Hz
+	sampleRate         = 44100  // Sample rate everything is rendered at, before resampling to the output rate
+	receiverRadius     = 0.5    // Radius in metres of the detector circle around each ear
+	referenceDistance  = 1.0    // Distance in metres at which the direct sound has unit energy
+	headRadius         = 0.0875 // Distance in metres from the centre of the head to each ear
+	headingStep        = math.Pi / 12
+	volume             = 1000 // 16-bit steps per unit of the mixer's output on the audio device
+)
+
+func (g *Game) Update() error {
+	g.frame++
+
+	// Get current mouse position in scene coordinates
+	x, y := ebiten.CursorPosition()
+	mousePosition := g.view.toWorld(Vector{float64(x), float64(y)})
+
+	// Check mouse button state
+	if inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+		if source := g.sourceNear(mousePosition); source != -1 {
+			// Pick up the source under the mouse
+			g.selectedSource, g.draggedSource = source, source
+		} else {
+			// Otherwise set the listener position
+			g.moveListener(mousePosition)
+			g.isDragging = true // Start dragging
+		}
+	}
+
+	if inpututil.IsMouseButtonJustReleased(ebiten.MouseButtonLeft) {
+		// If the mouse button was released, stop dragging
+		g.isDragging = false
+		g.draggedSource = -1
+	}
+
+	// Whatever is dragged moves at the speed of the mouse, for the Doppler
+	// shift; a click that puts the listener somewhere else is a jump
+	g.listener.velocity = Vector{}
+	for i := range g.audioSources {
+		g.audioSources[i].velocity = Vector{}
+	}
+	if g.isDragging {
+		// Update the listener's position to follow the mouse while dragging
+		if !inpututil.IsMouseButtonJustPressed(ebiten.MouseButtonLeft) {
+			g.listener.velocity = perTick(g.listener.position, mousePosition)
+		}
+		g.moveListener(mousePosition)
+	}
+	if g.draggedSource != -1 {
+		source := &g.audioSources[g.draggedSource]
+		source.velocity = perTick(source.position, mousePosition)
+		source.position = mousePosition
+	}
+
+	// Add a source at the mouse, and switch off or remove the selected one
+	if inpututil.IsKeyJustPressed(ebiten.KeyA) {
+		g.selectedSource = g.addSource(newSineSource(mousePosition))
+	}
+	if g.selectedSource != -1 && g.draggedSource == -1 {
+		if inpututil.IsKeyJustPressed(ebiten.KeyT) {
+			g.toggleSource(g.selectedSource)
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyG) {
+			// Cycle through the built-in signals
+			if err := g.cycleGenerator(g.selectedSource); err != nil {
+				log.Printf("switching signal: %v", err)
+			}
+		}
+		if inpututil.IsKeyJustPressed(ebiten.KeyDelete) || inpututil.IsKeyJustPressed(ebiten.KeyBackspace) {
+			g.removeSource(g.selectedSource)
+		}
+	}
+
+	// Turn the listener with the mouse wheel or the arrow keys
+	_, wheel := ebiten.Wheel()
+	turn := wheel * headingStep
+	if inpututil.IsKeyJustPressed(ebiten.KeyArrowLeft) {
+		turn -= headingStep
+	}
+	if inpututil.IsKeyJustPressed(ebiten.KeyArrowRight) {
+		turn += headingStep
+	}
+	if turn != 0 {
+		g.listener.heading = math.Remainder(g.listener.heading+turn, 2*math.Pi)
+		g.listener.placeEars()
+	}
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyM) {
+		// Cycle through the propagation engines
+		g.engine = (g.engine + 1) % (engineImageSource + 1)
+		log.Printf("propagation engine: %v", g.engine)
+	}
+
+	g.simulate()
+
+	if inpututil.IsKeyJustPressed(ebiten.KeyE) && g.selectedSource != -1 {
+		// Export the selected source's impulse response at the current
+		// listener position
+		if err := g.exportImpulseResponse(g.selectedSource, "impulse_response.wav"); err != nil {
+			log.Printf("exporting impulse response: %v", err)
+		} else {
+			log.Printf("wrote impulse_response.wav")
+		}
+	}
+
+	return nil
+}
+
+// simulate finds the paths from every enabled source to the listener with the
+// selected propagation engine and hands the resulting impulse responses to the
+// sources' renderers.
+func (g *Game) simulate() {
+	g.prepareRenderers()
+	g.airAbsorption = g.atmosphere.absorptionCoefficients()
+
+	g.rays = make([]Ray, 0, numRays*len(g.audioSources))
+	g.leftPaths = make([]AudioPath, 0)
+	g.rightPaths = make([]AudioPath, 0)
+	g.rayPathPoints = make([][]RayPathPoint, 0, numRays)
+	initialIntensity := uniformBands(1.0)
+
+	if g.engine != engineImageSource {
+		for s, source := range g.audioSources {
+			if !source.enabled {
+				continue
+			}
+			for i := 0; i < numRays; i++ {
+				angle := float64(i) * 2 * math.Pi / float64(numRays)
+				direction := Vector{math.Cos(angle), math.Sin(angle)}
+				g.rays = append(g.rays, Ray{source.position, direction})
+				g.spawnRay(rayTask{ray: Ray{source.position, direction}, intensity: initialIntensity, specular: true, source: s})
+			}
+		}
+		g.traceRays()
+	}
+
+	g.responses = make([][][]float64, len(g.audioSources))
+	for s, source := range g.audioSources {
+		if !source.enabled {
+			continue
+		}
+		if g.engine != engineRayTracing {
+			g.addImageSourcePaths(s)
+		}
+		g.addDiffractionPaths(s)
+		var taps []dopplerTap
+		if g.doppler {
+			g.markDopplerPaths(s)
+			taps = g.buildDopplerTaps(s)
+		}
+		g.responses[s] = g.buildResponses(s)
+		g.renderers[s].setResponses(g.responses[s])
+		g.renderers[s].setTaps(taps)
+	}
+	g.updateMixer()
+}
+
+// perTick returns the velocity of something that moved from one position to
+// another in one tick.
+func perTick(from, to Vector) Vector {
+	tps := float64(ebiten.TPS())
+	return Vector{(to.x - from.x) * tps, (to.y - from.y) * tps}
+}
+
+// moveListener puts the listener's head at position, keeping its heading.
+func (g *Game) moveListener(position Vector) {
+	g.listener.position = position
+	g.listener.placeEars()
+}
+
+func calculateILD(direction Vector, isLeft bool) float64 {
+	const minAttenuation = 0.3
+
+	angle := math.Atan2(direction.y, direction.x) - math.Pi/2
+	shadowEffect := 0.5 * (1.0 + math.Abs(math.Sin(angle)))
+	if isLeft {
+		shadowEffect = 1.0 - shadowEffect
+	}
+	return minAttenuation + (1.0-minAttenuation)*shadowEffect
+}
+
+// Draw implements ebiten.Game's Draw function. It draws the game's walls, the
+// audio source and listener, and the intersection points of the rays with the
+// walls.
+func (g *Game) Draw(screen *ebiten.Image) {
+	screen.Fill(color.Black)
+
+	for _, path := range g.rayPathPoints {
+		for i := 0; i < len(path)-1; i++ {
+			// Get start intensity
+			startIntensity := path[i].intensity
+
+			// Get start and end positions on screen
+			start := g.view.toScreen(path[i].position)
+			end := g.view.toScreen(path[i+1].position)
+			startX, startY := float32(start.x), float32(start.y)
+			endX, endY := float32(end.x), float32(end.y)
+
+			// Calculate the total segment length in metres
+			totalLength := float32(distance(path[i].position, path[i+1].position))
+
+			// Draw multiple small segments to create gradient
+			numSegments := 50 // Increase for smoother gradient
+			for j := 0; j < numSegments; j++ {
+				progress := float64(j) / float64(numSegments)
+
+				// Interpolate positions
+				x1 := startX + float32(progress)*(endX-startX)
+				y1 := startY + float32(progress)*(endY-startY)
+				x2 := startX + float32(progress+1.0/float64(numSegments))*(endX-startX)
+				y2 := startY + float32(progress+1.0/float64(numSegments))*(endY-startY)
+
+				// Calculate distance from start for this segment
+				distanceFromStart := totalLength * float32(progress)
+
+				// Calculate intensity using inverse square law
+				// Add a small offset to prevent division by zero
+				relativeDistance := float64(distanceFromStart) / referenceDistance
+				segmentIntensity := startIntensity / (1 + relativeDistance*relativeDistance)
+
+				// Calculate color for this segment
+				r := uint8(150 * segmentIntensity)
+				g := uint8(0)
+				b := uint8(10 - 10*segmentIntensity)
+				a := uint8(20 * segmentIntensity)
+
+				rayColor := color.RGBA{r, g, b, a}
+
+				// Draw the small segment
+				vector.StrokeLine(screen, x1, y1, x2, y2, 5, rayColor, true)
+			}
+		}
+	}
+	// Draw walls
+	for _, wall := range g.walls {
+		start, end := g.view.toScreen(wall.start), g.view.toScreen(wall.end)
+		vector.StrokeLine(screen, float32(start.x), float32(start.y), float32(end.x), float32(end.y), 1, color.RGBA{255, 255, 255, 255}, true)
+	}
+	// Draw audio sources, dimmed when switched off and ringed when selected
+	for i, audioSource := range g.audioSources {
+		source := g.view.toScreen(audioSource.position)
+		sourceColor := color.RGBA{255, 255, 255, 255}
+		if !audioSource.enabled {
+			sourceColor = color.RGBA{100, 100, 100, 255}
+		}
+		vector.DrawFilledCircle(screen, float32(source.x), float32(source.y), 5, sourceColor, true)
+		if i == g.selectedSource {
+			vector.StrokeCircle(screen, float32(source.x), float32(source.y), 9, 1, sourceColor, true)
+		}
+	}
+
+	// Draw listener
+	listener := g.view.toScreen(g.listener.position)
+	vector.DrawFilledCircle(screen, float32(listener.x), float32(listener.y), 5, color.RGBA{0, 0, 255, 100}, true)
+	facing := g.listener.facing()
+	nose := Vector{listener.x + 15*facing.x, listener.y + 15*facing.y}
+	vector.StrokeLine(screen, float32(listener.x), float32(listener.y), float32(nose.x), float32(nose.y), 2, color.RGBA{0, 0, 255, 200}, true)
+
+	// Draw the loudspeakers around the listener when panning onto them
+	if g.speakers != nil {
+		left := Vector{facing.y, -facing.x}
+		for ch, angle := range g.speakers.angles {
+			if ch == g.speakers.lfe {
+				continue
+			}
+			sin, cos := math.Sincos(angle * math.Pi / 180)
+			x := listener.x + 30*(cos*facing.x+sin*left.x)
+			y := listener.y + 30*(cos*facing.y+sin*left.y)
+			vector.DrawFilledRect(screen, float32(x)-3, float32(y)-3, 6, 6, color.RGBA{0, 200, 0, 200}, true)
+		}
+	}
+
+	status := ""
+	if g.selectedSource != -1 {
+		signal := g.audioSources[g.selectedSource].generator
+		if signal == "" {
+			signal = "file"
+		}
+		status = fmt.Sprintf("selected source: %s\n", signal)
+	}
+	if g.stream != nil {
+		status += fmt.Sprintf("audio buffered: %v, underruns: %d frames",
+			g.stream.latency().Round(time.Millisecond), g.stream.underruns.Load())
+	}
+	ebitenutil.DebugPrint(screen, status)
+	g.drawMeters(screen)
+}
+
+// drawMeters draws the master bus meters in the top right corner: a bar per
+// output channel from -60 to 0 dBFS with its RMS filled in and its peak as a
+// line, and below them the true peak, the limiter's gain reduction and the
+// loudness.
+func (g *Game) drawMeters(screen *ebiten.Image) {
+	if g.master == nil {
+		return
+	}
+	readings := g.master.readings.Load()
+	if readings == nil {
+		return
+	}
+
+	const floor, height, width = -60.0, 120, 8
+	level := func(db float64) float32 {
+		return float32(height * math.Max(0, math.Min(1, 1-db/floor)))
+	}
+	left := float32(screenWidth - 210)
+	for ch := range readings.peak {
+		x := left + float32(ch*(width+2))
+		vector.DrawFilledRect(screen, x, 10, width, height, color.RGBA{40, 40, 40, 255}, false)
+		rms := level(readings.rms[ch])
+		vector.DrawFilledRect(screen, x, 10+height-rms, width, rms, color.RGBA{0, 160, 0, 255}, false)
+		peakColor := color.RGBA{200, 200, 0, 255}
+		if readings.peak[ch] > g.master.ceiling {
+			peakColor = color.RGBA{255, 0, 0, 255}
+		}
+		peak := 10 + height - level(readings.peak[ch])
+		vector.StrokeLine(screen, x, peak, x+width, peak, 2, peakColor, false)
+	}
+
+	loudness := func(lufs float64) string {
+		if math.IsInf(lufs, -1) {
+			return "  -inf"
+		}
+		return fmt.Sprintf("%6.1f", lufs)
+	}
+	text := fmt.Sprintf("true peak %6.1f dBTP\nreduction %6.1f dB\nM %s  S %s\nI %s LUFS",
+		readings.truePeak, readings.reduction,
+		loudness(readings.momentary), loudness(readings.shortTerm), loudness(readings.integrated))
+	if g.master.normalize {
+		text += fmt.Sprintf("\nnormalisation %+.1f dB", readings.normalization)
+	}
+	ebitenutil.DebugPrintAt(screen, text, int(left), 15+height)
+}
+
+func (g *Game) Layout(outsideWidth, outsideHeight int) (int, int) {
+	return screenWidth, screenHeight
+}
+
+// main sets up the game state (walls, audio source and listener), from a scene
+// file if one is given. With -render it renders the scene offline to a WAV file
+// and exits. Otherwise it creates an oto audio context and player for the
+// game, sets up the Ebiten window and starts the game loop with
+// ebiten.RunGame. If there's an error, it logs the error and exits.
+func main() {
+	sourceFile := flag.String("source", "", "WAV, MP3, FLAC or Ogg Vorbis file played by the first source instead of a sine")
+	loop := flag.Bool("loop", true, "start the source file over when it ends")
+	gain := flag.Float64("gain", 1, "linear gain applied to the source file")
+	offset := flag.Duration("offset", 0, "position in the source file to start playing from")
+	generator := flag.String("signal", "", "built-in signal played by the first source instead of a sine: "+strings.Join(generatorTypes, ", ")+
+		", with optional parameters as in sweep:from=20,to=20000,length=5,gap=1")
+	scenePath := flag.String("scene", "", "JSON scene with walls and source and listener trajectories")
+	render := flag.String("render", "", "render the scene offline to this WAV file instead of opening a window")
+	updateInterval := flag.Duration("update", 50*time.Millisecond, "how often the offline renderer re-simulates the scene")
+	latency := flag.Duration("latency", 100*time.Millisecond, "how much audio to buffer ahead of the audio device")
+	crossfade := flag.Duration("crossfade", 50*time.Millisecond, "how long to crossfade between impulse responses when the scene changes")
+	radius := flag.Float64("head-radius", headRadius, "radius of the listener's head in metres, used for the ear positions and interaural time differences")
+	hrtfPath := flag.String("hrtf", "", "HRTF set exported from a SOFA file to JSON, used instead of level-only panning")
+	interpolateHRTF := flag.Bool("hrtf-interpolate", true, "blend the two nearest HRIRs instead of using the nearest one")
+	ambisonicOrder := flag.Int("ambisonics", 0, "render Ambisonics (ACN/SN3D) of this order instead of binaural audio; needs -render")
+	horizontal := flag.Bool("ambisonics-horizontal", false, "keep only the horizontal Ambisonic components, 2·order+1 channels")
+	doppler := flag.Bool("doppler", true, "shift the pitch of the strongest image-source paths as sources and the listener move")
+	rate := flag.Int("rate", sampleRate, "output sample rate in Hz: 44100, 48000 or 96000, resampled from the internal 44100 Hz")
+	sampleFormatName := flag.String("sample-format", "", "output samples: int16, int24 or float32; float32 for -render and int16 for the audio device by default")
+	channelsName := flag.String("channels", "", "output channels: mono to mix the output down, or stereo or a number matching the rendered output")
+	ceiling := flag.Float64("ceiling", -1, "true-peak ceiling of the output limiter in dBTP")
+	normalize := flag.Float64("normalize", 0, "normalise the output loudness to this many LUFS, such as -23 for EBU R128; 0 leaves it as it is")
+	speakers := flag.String("speakers", "", "pan onto loudspeakers instead of rendering binaural audio: stereo, quad, 5.1, 7.1 or a comma-separated list of angles in degrees anticlockwise from ahead; more than two need -render")
+	flag.Parse()
+
+	var scene *sceneFile
+	game := &Game{
+		// A 24 m by 12 m room with a curtain partition near its left wall
+		walls: []Wall{
+			{Vector{0, 0}, Vector{24, 0}, plasterWall},
+			{Vector{24, 0}, Vector{24, 12}, plasterWall},
+			{Vector{24, 12}, Vector{0, 12}, plasterWall},
+			{Vector{0, 12}, Vector{0, 0}, plasterWall},
+			{Vector{2.5, 9.5}, Vector{2.5, 2.5}, curtainPartition},
+		},
+		view:             View{pixelsPerMeter: 60, offset: Vector{240, 180}},
+		atmosphere:       standardAtmosphere(),
+		maxOrder:         maxReflectionOrder,
+		imageSourceOrder: imageSourceOrder,
+		crossfade:        int(crossfade.Seconds() * sampleRate),
+		draggedSource:    -1,
+	}
+	game.addSource(newSineSource(Vector{12.5, 6}))
+	game.listener = Listener{heading: -math.Pi / 2, headRadius: *radius}
+	game.moveListener(Vector{9.5, 6})
+	game.getWallEdges()
+	game.buildSceneIndex()
+	if *scenePath != "" {
+		var err error
+		if scene, err = loadScene(*scenePath); err != nil {
+			log.Fatal(err)
+		}
+		if game, err = scene.newGame(int(crossfade.Seconds() * sampleRate)); err != nil {
+			log.Fatal(err)
+		}
+		game.listener.headRadius = *radius
+		game.listener.placeEars()
+	}
+
+	var file *fileSignal
+	if *sourceFile != "" {
+		var err error
+		if file, err = newFileSignal(*sourceFile, *loop, *gain, *offset); err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		game.audioSources[0].signal = file
+		game.audioSources[0].generator = ""
+	}
+	if *generator != "" {
+		if *sourceFile != "" {
+			log.Fatal("-signal and -source both choose the first source's signal")
+		}
+		spec, err := parseGeneratorSpec(*generator)
+		if err != nil {
+			log.Fatal(err)
+		}
+		dry, err := spec.signal()
+		if err != nil {
+			log.Fatal(err)
+		}
+		game.audioSources[0].signal = dry
+		game.audioSources[0].generator = spec.Type
+	}
+	game.doppler = *doppler
+	if *hrtfPath != "" {
+		var err error
+		if game.hrtf, err = loadHRTF(*hrtfPath); err != nil {
+			log.Fatal(err)
+		}
+		game.hrtf.interpolate = *interpolateHRTF
+	}
+	if *ambisonicOrder > 0 {
+		if *render == "" {
+			log.Fatal("-ambisonics needs -render: the audio device plays binaural audio only")
+		}
+		game.ambisonics = &ambisonics{order: *ambisonicOrder, horizontal: *horizontal}
+	}
+	if *speakers != "" {
+		if game.ambisonics != nil {
+			log.Fatal("-speakers and -ambisonics both choose the output format")
+		}
+		var err error
+		if game.speakers, err = parseSpeakerLayout(*speakers); err != nil {
+			log.Fatal(err)
+		}
+	}
+	game.prepareRenderers()
+
+	format := outputFormat{rate: *rate, sample: formatInt16, channels: game.outputChannels()}
+	if *render != "" {
+		format.sample = formatFloat32
+	}
+	if err := checkRate(*rate); err != nil {
+		log.Fatal(err)
+	}
+	if *sampleFormatName != "" {
+		var err error
+		if format.sample, err = parseSampleFormat(*sampleFormatName); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *channelsName != "" {
+		if game.ambisonics != nil {
+			log.Fatal("-channels doesn't apply to Ambisonics, which decoders mix down")
+		}
+		var err error
+		if format.channels, err = parseChannels(*channelsName, game.outputChannels()); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *render == "" && format.channels > 2 {
+		log.Fatalf("the audio device plays mono or stereo only; -speakers %s needs -render, or -channels mono to mix it down", *speakers)
+	}
+
+	master := masterSettings{gain: float64(volume) / math.MaxInt16, ceiling: *ceiling, normalize: *normalize != 0, target: *normalize}
+	if *render != "" {
+		// Files take the mixer's output at full scale
+		master.gain = 1
+	}
+	game.master = newMasterBus(game.loudnessWeights(), master)
+
+	if *render != "" {
+		if scene == nil {
+			log.Fatal("-render needs a -scene")
+		}
+		duration := scene.duration(game.audioSources)
+		if duration <= 0 {
+			log.Fatal("the scene has no duration; set one in the scene file")
+		}
+		start := time.Now()
+		channels := game.renderOffline(scene, duration, updateInterval.Seconds())
+		game.master.processAll(channels)
+		channels = format.convert(channels)
+		mask := game.channelMask()
+		if format.channels != game.outputChannels() {
+			mask = 0
+		}
+		if err := writeWAVFile(*render, format.rate, format.sample, channels, mask); err != nil {
+			log.Fatal(err)
+		}
+		readings := game.master.readings.Load()
+		log.Printf("rendered %.1f s to %s in %v: true peak %.1f dBTP, loudness %.1f LUFS", duration, *render,
+			time.Since(start).Round(time.Millisecond), readings.truePeak, readings.integrated)
+		return
+	}
+
+	// Of the latency, the stream's ring buffer holds half and the player and
+	// the device a quarter each
+	deviceFormat := map[sampleFormat]int{formatInt16: oto.FormatSignedInt16LE, formatFloat32: oto.FormatFloat32LE}
+	if _, ok := deviceFormat[format.sample]; !ok {
+		log.Fatalf("the audio device takes int16 or float32 samples, not %v", format.sample)
+	}
+	otoCtx, readyChan, err := oto.NewContextWithOptions(&oto.NewContextOptions{
+		SampleRate:   format.rate,
+		ChannelCount: format.channels,
+		Format:       deviceFormat[format.sample],
+		BufferSize:   *latency / 4,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	<-readyChan
+	game.audioContext = otoCtx
+	fmt.Println(game.wallEdges)
+
+	game.stream = newAudioStream(game.mixer, game.master, format, *latency/2)
+	defer game.stream.close()
+	game.player = otoCtx.NewPlayer(game.stream)
+	if player, ok := game.player.(oto.BufferSizeSetter); ok {
+		player.SetBufferSize(format.frames(*latency/4) * format.frameBytes())
+	}
+
+	ebiten.SetWindowSize(screenWidth, screenHeight)
+	ebiten.SetWindowTitle("2D Audio Ray Tracing")
+	ebiten.SetTPS(2)
+
+	game.player.Play()
+
+	if err := ebiten.RunGame(game); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Returns all edges/corners for diffraction calculations
+func (g *Game) getWallEdges() {
+	edges := make([]WallEdge, 0)
+	pointMap := make(map[Vector]bool)
+
+	// Iterate through each wall
+	for i, wall1 := range g.walls {
+		// Calculate the wall's direction and normal
+		wallDir := Vector{
+			wall1.end.x - wall1.start.x,
+			wall1.end.y - wall1.start.y,
+		}.normalize()
+		wallNormal := Vector{-wallDir.y, wallDir.x}
+
+		// Add wall endpoints
+		for _, point := range []Vector{wall1.start, wall1.end} {
+			if !pointMap[point] {
+				edges = append(edges, WallEdge{
+					position: point,
+					normal1:  wallNormal,
+					wall1:    i,
+					wall2:    -1,
+					isCorner: false,
+				})
+				pointMap[point] = true
+			}
+		}
+
+		// Check if the end of wall1 is a corner shared with another wall
+		for j, wall2 := range g.walls {
+			if i == j {
+				continue // Skip if it's the same wall
+			}
+			wall2Dir := Vector{
+				wall2.end.x - wall2.start.x,
+				wall2.end.y - wall2.start.y,
+			}.normalize()
+			wall2Normal := Vector{-wall2Dir.y, wall2Dir.x}
+
+			if wall1.start.x == wall2.end.x && wall1.start.y == wall2.end.y {
+				// Mark the point as a corner
+				for k, edge := range edges {
+					if edge.position == wall1.start {
+						edges[k].isCorner = true
+						edges[k].wall2, edges[k].normal2 = j, wall2Normal
+						if edge.wall1 == j {
+							edges[k].wall2, edges[k].normal2 = i, wallNormal
+						}
+						break
+					}
+				}
+			}
+			if wall1.end.x == wall2.start.x && wall1.end.y == wall2.start.y {
+				// Mark the point as a corner
+				for k, edge := range edges {
+					if edge.position == wall1.end {
+						edges[k].isCorner = true
+						edges[k].wall2, edges[k].normal2 = j, wall2Normal
+						if edge.wall1 == j {
+							edges[k].wall2, edges[k].normal2 = i, wallNormal
+						}
+						break
+					}
+				}
+			}
+		}
+	}
+
+	g.wallEdges = edges
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+const (
+	// Fastest change of the loudness normalisation gain while playing live,
+	// in dB per second.
+	normalizationRate = 1.0
+	// How fast the peak meters fall back after a peak, in dB per second.
+	peakFall = 20.0
+	// Time constant of the RMS meters, in seconds.
+	rmsTime = 0.3
+)
+
+// masterSettings configures the master bus.
+type masterSettings struct {
+	gain      float64 // linear gain from the mixer's output to full scale
+	ceiling   float64 // true-peak ceiling of the limiter in dBTP
+	normalize bool    // normalise the loudness to target
+	target    float64 // in LUFS
+}
+
+// meterReadings are the master bus meters after a block, all of them
+// measured on its output.
+type meterReadings struct {
+	peak, rms []float64 // per channel in dBFS, the peak falling back at peakFall
+	truePeak  float64   // highest true peak so far in dBTP
+	reduction float64   // largest gain reduction of the limiter over the block in dB
+	// Loudness in LUFS, -Inf until there is enough of it
+	momentary, shortTerm, integrated float64
+	normalization                    float64 // gain of the loudness normalisation in dB
+}
+
+// masterBus takes the mix of all sources in floating point and gets it ready
+// for the sink: it applies the master gain and the loudness normalisation,
+// keeps the true peak under the ceiling with a look-ahead limiter, and meters
+// the result. A single goroutine processes while any other reads the meters.
+type masterBus struct {
+	masterSettings
+	input         *loudnessMeter // loudness before normalisation, while normalising live
+	fixed         bool           // the normalisation gain was set from the whole signal
+	normalization float64        // in dB
+	limiter       *truePeakLimiter
+	truePeak      *truePeakDetector
+	loudness      *loudnessMeter
+	peaks         []float64 // held peak per channel, linear
+	squares       []float64 // mean square per channel
+	highest       float64   // highest true peak, linear
+	frame         []float64
+	readings      atomic.Pointer[meterReadings]
+}
+
+// newMasterBus returns a master bus for channels that count towards loudness
+// with the given weights.
+func newMasterBus(weights []float64, settings masterSettings) *masterBus {
+	b := &masterBus{
+		masterSettings: settings,
+		limiter:        newTruePeakLimiter(len(weights), settings.ceiling),
+		truePeak:       newTruePeakDetector(len(weights)),
+		loudness:       newLoudnessMeter(weights, sampleRate),
+		peaks:          make([]float64, len(weights)),
+		squares:        make([]float64, len(weights)),
+		frame:          make([]float64, len(weights)),
+	}
+	if settings.normalize {
+		b.input = newLoudnessMeter(weights, sampleRate)
+	}
+	return b
+}
+
+// channels returns the number of channels the bus processes.
+func (b *masterBus) channels() int {
+	return len(b.peaks)
+}
+
+// process runs a block, one slice per channel, through the bus in place.
+// The output lags the input by the limiter's delay.
+func (b *masterBus) process(channels [][]float64) {
+	n := len(channels[0])
+	for _, channel := range channels {
+		for i := range channel {
+			channel[i] *= b.gain
+		}
+	}
+
+	// Live, the normalisation follows the loudness of what has played so
+	// far, gliding across each block so the gain never steps
+	from := b.normalization
+	if b.normalize && !b.fixed {
+		b.input.process(channels)
+		if integrated := b.input.integrated(); !math.IsInf(integrated, -1) {
+			limit := normalizationRate * float64(n) / sampleRate
+			b.normalization += math.Max(-limit, math.Min(limit, b.target-integrated-b.normalization))
+		}
+	}
+	for _, channel := range channels {
+		for i := range channel {
+			normalization := from + (b.normalization-from)*float64(i+1)/float64(n)
+			channel[i] *= math.Pow(10, normalization/20)
+		}
+	}
+
+	b.limiter.process(channels)
+	b.meter(channels)
+}
+
+// meter measures the bus output and publishes the readings.
+func (b *masterBus) meter(channels [][]float64) {
+	n := len(channels[0])
+	fall := math.Pow(10, -peakFall*float64(n)/sampleRate/20)
+	smoothing := 1 - math.Exp(-1/(rmsTime*sampleRate))
+	for ch, channel := range channels {
+		peak := b.peaks[ch] * fall
+		for _, x := range channel {
+			peak = math.Max(peak, math.Abs(x))
+			b.squares[ch] += (x*x - b.squares[ch]) * smoothing
+		}
+		b.peaks[ch] = peak
+	}
+	for i := 0; i < n; i++ {
+		for ch, channel := range channels {
+			b.frame[ch] = channel[i]
+		}
+		b.highest = math.Max(b.highest, b.truePeak.push(b.frame))
+	}
+	b.loudness.process(channels)
+
+	readings := &meterReadings{
+		peak:          make([]float64, len(b.peaks)),
+		rms:           make([]float64, len(b.peaks)),
+		truePeak:      decibels(b.highest),
+		reduction:     -decibels(b.limiter.takeLowestGain()),
+		momentary:     b.loudness.momentary(),
+		shortTerm:     b.loudness.shortTerm(),
+		integrated:    b.loudness.integrated(),
+		normalization: b.normalization,
+	}
+	for ch := range b.peaks {
+		readings.peak[ch] = decibels(b.peaks[ch])
+		readings.rms[ch] = decibels(math.Sqrt(b.squares[ch]))
+	}
+	b.readings.Store(readings)
+}
+
+// processAll runs whole signals through the bus in place, without the
+// limiter's delay. When normalising, the gain comes from the integrated
+// loudness of the whole of them, as EBU R128 intends for finished programmes.
+func (b *masterBus) processAll(channels [][]float64) {
+	frames := len(channels[0])
+	block := make([][]float64, len(channels))
+	blocks := func(do func(block [][]float64)) {
+		for start := 0; start < frames; start += convolutionBlockSize {
+			for ch, channel := range channels {
+				block[ch] = channel[start:min(frames, start+convolutionBlockSize)]
+			}
+			do(block)
+		}
+	}
+
+	if b.normalize {
+		meter := newLoudnessMeter(b.loudness.weights, sampleRate)
+		scaled := make([][]float64, len(channels))
+		blocks(func(block [][]float64) {
+			for ch, channel := range block {
+				scaled[ch] = append(scaled[ch][:0], channel...)
+				for i := range scaled[ch] {
+					scaled[ch][i] *= b.gain
+				}
+			}
+			meter.process(scaled)
+		})
+		if integrated := meter.integrated(); !math.IsInf(integrated, -1) {
+			b.normalization = b.target - integrated
+		}
+		b.fixed = true
+	}
+
+	blocks(b.process)
+
+	// Flush the end out of the limiter with silence and drop as much from
+	// the start
+	delay := min(frames, b.limiter.delay())
+	tail := make([][]float64, len(channels))
+	for ch := range tail {
+		tail[ch] = make([]float64, b.limiter.delay())
+	}
+	b.process(tail)
+	for ch, channel := range channels {
+		copy(channel, channel[delay:])
+		copy(channel[frames-delay:], tail[ch][len(tail[ch])-delay:])
+	}
+}
+
+// decibels returns a linear amplitude in dB.
+func decibels(amplitude float64) float64 {
+	return 20 * math.Log10(amplitude)
+}
+
+// loudnessWeights returns how much each output channel counts towards
+// loudness by ITU-R BS.1770: surround speakers between 60° and 120° either
+// side count 1.41 times, the LFE channel not at all, and of Ambisonics only
+// the omnidirectional W channel counts.
+func (g *Game) loudnessWeights() []float64 {
+	weights := make([]float64, g.outputChannels())
+	switch {
+	case g.ambisonics != nil:
+		weights[0] = 1
+	case g.speakers != nil:
+		for ch, angle := range g.speakers.angles {
+			if side := math.Abs(math.Remainder(angle, 360)); ch == g.speakers.lfe {
+				weights[ch] = 0
+			} else if side >= 60 && side <= 120 {
+				weights[ch] = 1.41
+			} else {
+				weights[ch] = 1
+			}
+		}
+	default:
+		weights[0], weights[1] = 1, 1
+	}
+	return weights
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMasterBusNormalizesWholeSignals(t *testing.T) {
+	tests := []struct {
+		level, target float64
+	}{
+		{-30, -23},
+		{-10, -16},
+		{-3, -14},
+	}
+	for _, tt := range tests {
+		channels := toneAt(1000, tt.level, 5)
+		bus := newMasterBus([]float64{1, 1}, masterSettings{gain: 1, ceiling: -1, normalize: true, target: tt.target})
+		bus.processAll(channels)
+		if len(channels[0]) != 5*sampleRate {
+			t.Fatalf("%v LUFS: %d frames after processing, want %d", tt.level, len(channels[0]), 5*sampleRate)
+		}
+
+		// The tone starts at once, without the limiter's delay, and only
+		// the normalisation changes it
+		readings := bus.readings.Load()
+		want := toneAt(1000, tt.level+readings.normalization, 1)[0][1]
+		if got := channels[0][1]; math.Abs(got-want) > 1e-9 {
+			t.Errorf("%v LUFS: second sample = %v, want %v", tt.level, got, want)
+		}
+		if math.Abs(readings.integrated-tt.target) > 0.1 {
+			t.Errorf("%v LUFS: normalised to %.2f LUFS, want %v", tt.level, readings.integrated, tt.target)
+		}
+		if readings.truePeak > -1+0.1 {
+			t.Errorf("%v LUFS: true peak %.2f dBTP over the ceiling", tt.level, readings.truePeak)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// Number of retired signals that can wait for the renderer to close them.
+const maxRetired = 16
+
+// mixer sums the output of one convolver per source, each scaled by the
+// source's gain. The set of channels is replaced as a whole from any goroutine
+// while a single goroutine renders.
+type mixer struct {
+	channels atomic.Pointer[[]mixerChannel]
+	retired  chan io.Closer // signals of channels swapped out, to be closed
+	scratch  [][]float64
+}
+
+func newMixer() *mixer {
+	return &mixer{retired: make(chan io.Closer, maxRetired)}
+}
+
+type mixerChannel struct {
+	renderer *convolver
+	gain     float64
+}
+
+func (m *mixer) setChannels(channels []mixerChannel) {
+	m.channels.Store(&channels)
+}
+
+// retire hands over a signal that setChannels has already swapped out, for
+// render to close once it can no longer be reading it. It blocks while
+// maxRetired signals are waiting.
+func (m *mixer) retire(signal io.Closer) {
+	m.retired <- signal
+}
+
+// render fills each output with the mix of all channels, such as left and
+// right for binaural output.
+func (m *mixer) render(out ...[]float64) {
+	// Whatever was retired before this block was swapped out before it too,
+	// so the last block to read it has finished
+	for len(m.retired) > 0 {
+		signal := <-m.retired
+		signal.Close()
+	}
+
+	for _, output := range out {
+		clear(output)
+	}
+	channels := m.channels.Load()
+	if channels == nil || len(out) == 0 {
+		return
+	}
+
+	n := len(out[0])
+	for len(m.scratch) < len(out) {
+		m.scratch = append(m.scratch, nil)
+	}
+	scratch := m.scratch[:len(out)]
+	for i := range scratch {
+		if cap(scratch[i]) < n {
+			scratch[i] = make([]float64, n)
+		}
+		scratch[i] = scratch[i][:n]
+	}
+	for _, channel := range *channels {
+		channel.renderer.render(scratch...)
+		for i, output := range out {
+			for j, sample := range scratch[i] {
+				output[j] += channel.gain * sample
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import "math"
+
+// Distance in metres a source or the listener may move before the offline
+// renderer simulates the scene again. In between, the delay taps keep moving
+// the direct sound and early reflections at their rates, and only the rest of
+// the impulse response lags behind.
+const resimulateDistance = 0.25
+
+// duration returns how long to render the scene for: the duration set in the
+// scene, or else until the trajectories and the sources' files have ended and
+// the longest possible impulse response has died away.
+func (s *sceneFile) duration(sources []AudioSource) float64 {
+	if s.Duration > 0 {
+		return s.Duration
+	}
+	duration := s.Listener.end()
+	for _, spec := range s.Sources {
+		duration = math.Max(duration, spec.Path.end())
+	}
+	for _, source := range sources {
+		if file, ok := source.signal.(*fileSignal); ok && !math.IsInf(file.duration, 1) {
+			duration = math.Max(duration, file.duration+maxImpulseResponseSeconds)
+		}
+	}
+	return duration
+}
+
+// renderOffline renders duration seconds of the sources' dry signals as heard
+// by the listener, one slice per output channel, while they all follow the
+// scene's trajectories. Every updateInterval seconds of output the scene is
+// simulated again if a source or the listener has changed speed, or has moved
+// more than resimulateDistance since the last simulation. It runs as fast as
+// the tracer and the convolver allow and needs neither a window nor an audio
+// device.
+func (g *Game) renderOffline(scene *sceneFile, duration, updateInterval float64) [][]float64 {
+	frames := int(math.Ceil(duration * sampleRate))
+	hop := max(1, int(updateInterval*sampleRate))
+	channels := make([][]float64, g.outputChannels())
+	for ch := range channels {
+		channels[ch] = make([]float64, frames)
+	}
+	block := make([][]float64, len(channels))
+
+	// Positions of the sources and then the listener at the last simulation
+	var simulated []Vector
+	for start := 0; start < frames; start += hop {
+		t := float64(start) / sampleRate
+		changed := start == 0
+		for i, spec := range scene.Sources {
+			g.audioSources[i].position = spec.Path.at(t)
+			if velocity := spec.Path.velocity(t); velocity != g.audioSources[i].velocity {
+				// A source that stops needs its delay taps stopped too
+				g.audioSources[i].velocity, changed = velocity, true
+			}
+		}
+		if listener := scene.Listener.at(t); listener != g.listener.position {
+			g.moveListener(listener)
+		}
+		if velocity := scene.Listener.velocity(t); velocity != g.listener.velocity {
+			g.listener.velocity, changed = velocity, true
+		}
+
+		positions := make([]Vector, 0, len(g.audioSources)+1)
+		for _, source := range g.audioSources {
+			positions = append(positions, source.position)
+		}
+		positions = append(positions, g.listener.position)
+		for i := range simulated {
+			changed = changed || distance(positions[i], simulated[i]) > resimulateDistance
+		}
+		if changed {
+			g.simulate()
+			simulated = positions
+		}
+
+		end := min(frames, start+hop)
+		for ch, channel := range channels {
+			block[ch] = channel[start:end]
+		}
+		g.mixer.render(block...)
+	}
+	return channels
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRenderOfflineFollowsTrajectory(t *testing.T) {
+	scene, err := loadScene("scenes/walkthrough.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := scene.newGame(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scene.Listener = trajectory{{0, [2]float64{20, 6}}, {0.2, [2]float64{14, 6}}}
+	channels := g.renderOffline(scene, 0.3, 0.05)
+	left, right := channels[0], channels[1]
+
+	if len(left) != int(math.Ceil(0.3*sampleRate)) || len(right) != len(left) {
+		t.Fatalf("rendered %d and %d samples, want %v", len(left), len(right), math.Ceil(0.3*sampleRate))
+	}
+	if g.listener.position != (Vector{14, 6}) {
+		t.Errorf("listener ended at %v, want the last keyframe", g.listener.position)
+	}
+
+	// The listener walks towards the source, so the end is louder
+	rms := func(samples []float64) float64 {
+		sum := 0.0
+		for _, s := range samples {
+			sum += s * s
+		}
+		return math.Sqrt(sum / float64(len(samples)))
+	}
+	start, end := rms(left[2205:4410]), rms(left[len(left)-2205:])
+	if start == 0 || end <= start {
+		t.Errorf("RMS went from %v to %v, want it to rise as the listener approaches", start, end)
+	}
+}
+
+func TestRenderOfflineResimulatesAfterMoving(t *testing.T) {
+	tests := []struct {
+		name  string
+		speed float64 // of the listener, in m/s
+	}{
+		{name: "creeping", speed: 0.2},
+		{name: "walking", speed: 1.5},
+		{name: "running", speed: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scene, err := loadScene("scenes/walkthrough.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			g, err := scene.newGame(2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			scene.Listener = trajectory{{0, [2]float64{20, 6}}, {1, [2]float64{20 - tt.speed, 6}}}
+			const duration, interval = 0.6, 0.05
+			g.renderOffline(scene, duration, interval)
+
+			// The direct path tells where the listener was simulated last
+			var direct AudioPath
+			for _, path := range g.leftPaths {
+				if path.source == 0 && path.imageKey == 1 {
+					direct = path
+				}
+			}
+			simulatedAt := g.listener
+			simulatedAt.position.x = 20
+			for simulatedAt.position.x > 20-tt.speed*duration {
+				simulatedAt.placeEars()
+				if math.Abs(distance(g.audioSources[0].position, simulatedAt.leftEar)/g.atmosphere.speedOfSound()-direct.delay) < 1e-9 {
+					break
+				}
+				simulatedAt.position.x -= 0.001
+			}
+
+			// The last simulation lags the listener by at most the distance
+			// that triggers one, plus the hop before it was noticed
+			lag := distance(simulatedAt.position, g.listener.position)
+			if maxLag := resimulateDistance + tt.speed*interval; lag > maxLag {
+				t.Errorf("last simulated %v m behind the listener, want at most %v", lag, maxLag)
+			}
+			// Motion under that distance doesn't simulate the scene again at all
+			if moved := tt.speed * duration; moved < resimulateDistance && distance(simulatedAt.position, Vector{20, 6}) > 1e-3 {
+				t.Errorf("listener moved %v m, less than resimulateDistance, yet was simulated again at %v", moved, simulatedAt.position)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import "math"
+
+const (
+	// Taps per output sample of the resampling filter when it doesn't
+	// decimate. More taps narrow the transition band below the Nyquist
+	// frequency.
+	resamplerTaps = 128
+	// Kaiser window shape of the resampling filter, for about 90 dB of
+	// stopband attenuation.
+	resamplerBeta = 9.0
+)
+
+// resampler converts multichannel audio between two sample rates whose ratio
+// is up/down in lowest terms. Each output sample is the windowed-sinc
+// interpolation of the input at its instant, which falls on one of up phases
+// between input samples; the filter for each phase is worked out in advance.
+// The filter's cutoff sits just below the lower of the two Nyquist
+// frequencies, so nothing folds back when the rate drops.
+//
+// The resampler streams: input is fed in blocks of any length, and output
+// is produced as soon as the input around it has arrived, which lags by half
+// the filter length.
+type resampler struct {
+	up, down int
+	phases   [][]float64 // filter taps per phase, oldest input first
+	input    [][]float64 // unconsumed input per channel, from the first tap of the next output
+	phase    int         // phase of the next output
+	output   [][]float64
+}
+
+// newResampler returns a resampler from one rate to another for the given
+// number of channels.
+func newResampler(from, to, channels int) *resampler {
+	divisor := gcd(from, to)
+	r := &resampler{
+		up:     to / divisor,
+		down:   from / divisor,
+		input:  make([][]float64, channels),
+		output: make([][]float64, channels),
+	}
+
+	// Widen the filter and lower its cutoff in proportion when decimating
+	scale := math.Min(1, float64(r.up)/float64(r.down))
+	taps := 2 * int(math.Ceil(resamplerTaps/scale/2))
+	// Kaiser's design formulas give the attenuation of the window and the
+	// width of the transition band it needs, as a share of the input rate
+	attenuation := resamplerBeta/0.1102 + 8.7
+	transition := (attenuation - 8) / (2.285 * 2 * math.Pi * float64(taps))
+	cutoff := scale*0.5 - transition/2
+
+	r.phases = make([][]float64, r.up)
+	for p := range r.phases {
+		filter := make([]float64, taps)
+		sum := 0.0
+		for j := range filter {
+			// Distance from input tap j to the output instant
+			x := float64(p)/float64(r.up) + float64(taps/2-1-j)
+			filter[j] = 2 * cutoff * sinc(2*cutoff*x) * kaiser(x/float64(taps/2), resamplerBeta)
+			sum += filter[j]
+		}
+		for j := range filter {
+			filter[j] /= sum // pass DC at unity gain in every phase
+		}
+		r.phases[p] = filter
+	}
+
+	for ch := range r.input {
+		// The first output lines up with the first input sample, so the taps
+		// before it read silence
+		r.input[ch] = make([]float64, taps/2-1)
+	}
+	return r
+}
+
+// process feeds a block of input, one slice per channel, and returns the
+// output it completes. The output slices are reused by the next call.
+func (r *resampler) process(in [][]float64) [][]float64 {
+	for ch, channel := range in {
+		r.input[ch] = append(r.input[ch], channel...)
+	}
+	for ch := range r.output {
+		r.output[ch] = r.output[ch][:0]
+	}
+
+	taps := len(r.phases[0])
+	start := 0
+	for start+taps <= len(r.input[0]) {
+		filter := r.phases[r.phase]
+		for ch, input := range r.input {
+			y := 0.0
+			for j, h := range filter {
+				y += h * input[start+j]
+			}
+			r.output[ch] = append(r.output[ch], y)
+		}
+		r.phase += r.down
+		start += r.phase / r.up
+		r.phase %= r.up
+	}
+
+	for ch, input := range r.input {
+		r.input[ch] = input[:copy(input, input[start:])]
+	}
+	return r.output
+}
+
+// maxOutput returns the most output frames that processing the given number
+// of input frames can produce.
+func (r *resampler) maxOutput(frames int) int {
+	return frames*r.up/r.down + 1
+}
+
+// resample converts whole signals from one rate to another. The output is as
+// long as the input lasts at the new rate.
+func resample(channels [][]float64, from, to int) [][]float64 {
+	if from == to || len(channels) == 0 {
+		return channels
+	}
+	r := newResampler(from, to, len(channels))
+	frames := int(math.Ceil(float64(len(channels[0])) * float64(to) / float64(from)))
+	out := make([][]float64, len(channels))
+	for ch, channel := range r.process(channels) {
+		out[ch] = append(make([]float64, 0, frames), channel...)
+	}
+
+	// Flush the end through the filter with silence
+	tail := make([][]float64, len(channels))
+	for ch := range tail {
+		tail[ch] = make([]float64, len(r.phases[0]))
+	}
+	for ch, channel := range r.process(tail) {
+		out[ch] = append(out[ch], channel...)[:frames]
+	}
+	return out
+}
+
+// sinc returns the normalised sinc function sin(πx)/(πx).
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// kaiser returns the Kaiser window of shape beta at x in [-1, 1].
+func kaiser(x, beta float64) float64 {
+	if math.Abs(x) > 1 {
+		return 0
+	}
+	return besselI0(beta*math.Sqrt(1-x*x)) / besselI0(beta)
+}
+
+// besselI0 returns the modified Bessel function of the first kind of order
+// zero, summed from its power series.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > 1e-12*sum; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+	}
+	return sum
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
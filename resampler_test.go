@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// sineAt returns a second of a unit sine at the given frequency and rate.
+func sineAt(frequency float64, rate int) []float64 {
+	out := make([]float64, rate)
+	for i := range out {
+		out[i] = math.Sin(2 * math.Pi * frequency * float64(i) / float64(rate))
+	}
+	return out
+}
+
+// correlate returns the amplitude of the part of x that is a sine at the given
+// frequency and rate.
+func correlate(x []float64, frequency float64, rate int) float64 {
+	var re, im float64
+	for i, v := range x {
+		s, c := math.Sincos(2 * math.Pi * frequency * float64(i) / float64(rate))
+		re += v * c
+		im += v * s
+	}
+	return 2 * math.Hypot(re, im) / float64(len(x))
+}
+
+func TestResampleKeepsSines(t *testing.T) {
+	tests := []struct {
+		from, to  int
+		frequency float64
+	}{
+		{44100, 48000, 1000},
+		{44100, 48000, 18000},
+		{44100, 96000, 5000},
+		{48000, 44100, 1000},
+		{96000, 44100, 15000},
+	}
+	for _, tt := range tests {
+		out := resample([][]float64{sineAt(tt.frequency, tt.from)}, tt.from, tt.to)[0]
+		if len(out) != tt.to {
+			t.Fatalf("%d to %d Hz: got %d samples, want %d", tt.from, tt.to, len(out), tt.to)
+		}
+
+		// Leave out the edges, where the filter reads the silence around
+		// the signal
+		middle := out[tt.to/10 : tt.to*9/10]
+		if got := correlate(middle, tt.frequency, tt.to); math.Abs(got-1) > 1e-3 {
+			t.Errorf("%d to %d Hz: %v Hz sine has amplitude %v, want 1", tt.from, tt.to, tt.frequency, got)
+		}
+		residual := 0.0
+		for i, v := range middle {
+			s := math.Sin(2 * math.Pi * tt.frequency * float64(i+tt.to/10) / float64(tt.to))
+			residual = math.Max(residual, math.Abs(v-s))
+		}
+		if residual > 1e-3 {
+			t.Errorf("%d to %d Hz: %v Hz sine is off by up to %v", tt.from, tt.to, tt.frequency, residual)
+		}
+	}
+}
+
+func TestResampleRejectsImagesAndAliases(t *testing.T) {
+	// Upsampling a tone near the old Nyquist frequency leaves no image of it
+	// above, and downsampling a tone above the new Nyquist frequency leaves
+	// nothing folded back below
+	up := resample([][]float64{sineAt(20000, 44100)}, 44100, 96000)[0]
+	if image := correlate(up[9600:86400], 44100-20000, 96000); image > 1e-3 {
+		t.Errorf("image at 24100 Hz has amplitude %v", image)
+	}
+	down := resample([][]float64{sineAt(30000, 96000)}, 96000, 44100)[0]
+	if alias := correlate(down[4410:39690], 44100-30000, 44100); alias > 1e-3 {
+		t.Errorf("alias at 14100 Hz has amplitude %v", alias)
+	}
+}
+
+func TestResamplerStreamsLikeOneBlock(t *testing.T) {
+	in := sineAt(440, 44100)[:5000]
+	whole := newResampler(44100, 48000, 1).process([][]float64{in})[0]
+	whole = append([]float64(nil), whole...)
+
+	r := newResampler(44100, 48000, 1)
+	var streamed []float64
+	for start := 0; start < len(in); start += 333 {
+		out := r.process([][]float64{in[start:min(len(in), start+333)]})[0]
+		if len(out) > r.maxOutput(333) {
+			t.Fatalf("block at %d gave %d frames, more than maxOutput() = %d", start, len(out), r.maxOutput(333))
+		}
+		streamed = append(streamed, out...)
+	}
+	if len(streamed) != len(whole) {
+		t.Fatalf("streaming gave %d samples, one block %d", len(streamed), len(whole))
+	}
+	for i := range whole {
+		if math.Abs(streamed[i]-whole[i]) > 1e-12 {
+			t.Fatalf("sample %d = %v streamed, %v in one block", i, streamed[i], whole[i])
+		}
+	}
+}
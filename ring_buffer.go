@@ -0,0 +1,63 @@
+package main
+
+import "sync/atomic"
+
+// ringBuffer is a lock-free queue of interleaved multichannel frames between
+// one producer and one consumer goroutine. The read and write indices count
+// frames and only ever grow; the producer alone moves writeIndex and the
+// consumer alone moves readIndex, and the atomic stores publish the frames
+// written before them.
+type ringBuffer struct {
+	samples    []float32
+	channels   int
+	mask       uint64
+	readIndex  atomic.Uint64
+	writeIndex atomic.Uint64
+}
+
+// newRingBuffer returns a ring buffer holding at least capacity frames of the
+// given number of channels.
+func newRingBuffer(capacity, channels int) *ringBuffer {
+	size := 1
+	for size < capacity {
+		size <<= 1
+	}
+	return &ringBuffer{samples: make([]float32, size*channels), channels: channels, mask: uint64(size - 1)}
+}
+
+func (r *ringBuffer) capacity() int {
+	return int(r.mask) + 1
+}
+
+// fill returns the number of frames waiting to be read.
+func (r *ringBuffer) fill() int {
+	return int(r.writeIndex.Load() - r.readIndex.Load())
+}
+
+// write appends as many frames as fit, taking one sample from each channel
+// per frame, and returns how many that was. Only the producer may call it.
+func (r *ringBuffer) write(channels ...[]float64) int {
+	w := r.writeIndex.Load()
+	n := min(len(channels[0]), r.capacity()-int(w-r.readIndex.Load()))
+	for i := 0; i < n; i++ {
+		frame := r.samples[int((w+uint64(i))&r.mask)*r.channels:]
+		for ch, channel := range channels {
+			frame[ch] = float32(channel[i])
+		}
+	}
+	r.writeIndex.Store(w + uint64(n))
+	return n
+}
+
+// read takes up to len(samples)/channels interleaved frames and returns how
+// many frames it took. Only the consumer may call it.
+func (r *ringBuffer) read(samples []float32) int {
+	rd := r.readIndex.Load()
+	n := min(len(samples)/r.channels, int(r.writeIndex.Load()-rd))
+	for i := 0; i < n; i++ {
+		start := int((rd+uint64(i))&r.mask) * r.channels
+		copy(samples[i*r.channels:(i+1)*r.channels], r.samples[start:start+r.channels])
+	}
+	r.readIndex.Store(rd + uint64(n))
+	return n
+}
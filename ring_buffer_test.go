@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRingBufferWrapsAround(t *testing.T) {
+	r := newRingBuffer(5, 2)
+	if r.capacity() != 8 {
+		t.Fatalf("capacity() = %d, want 8", r.capacity())
+	}
+
+	frames := make([]float32, 2*8)
+	next := 0.0
+	for round := 0; round < 5; round++ {
+		left, right := make([]float64, 6), make([]float64, 6)
+		for i := range left {
+			left[i], right[i] = next+float64(i), -(next + float64(i))
+		}
+		if n := r.write(left, right); n != 6 {
+			t.Fatalf("round %d: write() = %d, want 6", round, n)
+		}
+		if n := r.write(left, right); n != 2 {
+			t.Fatalf("round %d: write() into a nearly full buffer = %d, want 2", round, n)
+		}
+		if r.fill() != 8 {
+			t.Fatalf("round %d: fill() = %d, want 8", round, r.fill())
+		}
+
+		if n := r.read(frames[:2*6]); n != 6 {
+			t.Fatalf("round %d: read() = %d, want 6", round, n)
+		}
+		for i := 0; i < 6; i++ {
+			frame := frames[2*i : 2*i+2]
+			if want := float32(next + float64(i)); frame[0] != want || frame[1] != -want {
+				t.Fatalf("round %d: frame %d = %v, want %v", round, i, frame, want)
+			}
+		}
+		r.read(frames) // drop the two extra frames
+		next += 6
+	}
+}
+
+func TestAudioStreamProducesAndCountsUnderruns(t *testing.T) {
+	c := newConvolver(constantSignal(1), 0)
+	c.setImpulseResponse(impulseResponse{left: []float64{0.5}, right: []float64{0.25}})
+	m := newMixer()
+	m.setChannels([]mixerChannel{{renderer: c, gain: 1}})
+	s := newAudioStream(m, newMasterBus([]float64{1, 1}, masterSettings{gain: float64(volume) / math.MaxInt16}), outputFormat{rate: sampleRate, sample: formatInt16, channels: 2}, 20*time.Millisecond)
+	defer s.close()
+
+	deadline := time.Now().Add(time.Second)
+	for s.ring.fill() < s.target-convolutionBlockSize && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	buf := make([]byte, 4*100)
+	if n, err := s.Read(buf); n != len(buf) || err != nil {
+		t.Fatalf("Read() = %d, %v", n, err)
+	}
+	left := int16(buf[396]) | int16(buf[397])<<8
+	right := int16(buf[398]) | int16(buf[399])<<8
+	if left != 0.5*volume || right != 0.25*volume {
+		t.Errorf("last frame = %d, %d, want %v, %v", left, right, 0.5*volume, 0.25*volume)
+	}
+	if s.underruns.Load() != 0 {
+		t.Errorf("underruns = %d after a read from a full buffer", s.underruns.Load())
+	}
+
+	// Asking for far more than is buffered plays silence for the rest
+	s.Read(make([]byte, 4*4*s.ring.capacity()))
+	if s.underruns.Load() == 0 {
+		t.Errorf("no underrun counted for a read past the buffered audio")
+	}
+}
+
+func TestAudioStreamConvertsFormat(t *testing.T) {
+	c := newConvolver(constantSignal(1), 0)
+	c.setImpulseResponse(impulseResponse{left: []float64{0.5}, right: []float64{0.25}})
+	m := newMixer()
+	m.setChannels([]mixerChannel{{renderer: c, gain: 1}})
+	s := newAudioStream(m, newMasterBus([]float64{1, 1}, masterSettings{gain: float64(volume) / math.MaxInt16}), outputFormat{rate: 48000, sample: formatFloat32, channels: 1}, 50*time.Millisecond)
+	defer s.close()
+
+	deadline := time.Now().Add(time.Second)
+	for s.ring.fill() < 1000 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Past the resampler's start the mono mix of the two constant channels
+	// comes out at its level
+	buf := make([]byte, 4*1000)
+	if n, err := s.Read(buf); n != len(buf) || err != nil {
+		t.Fatalf("Read() = %d, %v", n, err)
+	}
+	got := float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[len(buf)-4:])))
+	if want := 0.375 * float64(volume) / math.MaxInt16; math.Abs(got-want) > 1e-6 {
+		t.Errorf("last sample = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// sceneFile is the JSON description of a scene, with the listener and the
+// sources moving along trajectories. Positions are [x, y] pairs in metres and
+// times are in seconds. A lone "source" trajectory is shorthand for one sine
+// source following it.
+type sceneFile struct {
+	Materials  map[string]materialSpec `json:"materials"`
+	Walls      []wallSpec              `json:"walls"`
+	Source     trajectory              `json:"source"`
+	Sources    []sourceSpec            `json:"sources"`
+	Listener   trajectory              `json:"listener"`
+	Atmosphere *atmosphereSpec         `json:"atmosphere"`
+	Duration   float64                 `json:"duration"`
+
+	dir string // directory of the scene file, which file paths are relative to
+}
+
+// sourceSpec is a source that plays a file or a built-in signal, or a sine at
+// frequency (sineFreq if unset) when it has neither.
+type sourceSpec struct {
+	Path      trajectory     `json:"path"`
+	File      string         `json:"file"`
+	Loop      bool           `json:"loop"`
+	Offset    float64        `json:"offset"`
+	Signal    *generatorSpec `json:"signal"`
+	Frequency float64        `json:"frequency"`
+	Gain      *float64       `json:"gain"`
+	Disabled  bool           `json:"disabled"`
+}
+
+type materialSpec struct {
+	Absorption            bands   `json:"absorption"`
+	Transparency          bands   `json:"transparency"`
+	Roughness             float64 `json:"roughness"`
+	TransmissionRoughness float64 `json:"transmissionRoughness"`
+}
+
+type wallSpec struct {
+	Start    [2]float64 `json:"start"`
+	End      [2]float64 `json:"end"`
+	Material string     `json:"material"`
+}
+
+// atmosphereSpec overrides the fields of the standard atmosphere that are
+// set. Temperature is in °C, relative humidity in percent and pressure in kPa.
+type atmosphereSpec struct {
+	Temperature      *float64 `json:"temperature"`
+	RelativeHumidity *float64 `json:"relativeHumidity"`
+	Pressure         *float64 `json:"pressure"`
+}
+
+// trajectory is a path through the scene given by keyframes, between which
+// the position is interpolated linearly.
+type trajectory []keyframe
+
+type keyframe struct {
+	Time     float64    `json:"time"`
+	Position [2]float64 `json:"position"`
+}
+
+// builtinMaterials can be used by name in a scene without defining them.
+var builtinMaterials = map[string]WallProperties{
+	"plaster": plasterWall,
+	"curtain": curtainPartition,
+}
+
+// loadScene reads a scene from a JSON file.
+func loadScene(path string) (*sceneFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var scene sceneFile
+	if err := json.Unmarshal(data, &scene); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(scene.Source) > 0 {
+		scene.Sources = append([]sourceSpec{{Path: scene.Source}}, scene.Sources...)
+		scene.Source = nil
+	}
+	if len(scene.Sources) == 0 || len(scene.Listener) == 0 {
+		return nil, fmt.Errorf("%s: the scene needs a source and a listener", path)
+	}
+	for i, source := range scene.Sources {
+		if len(source.Path) == 0 {
+			return nil, fmt.Errorf("%s: source %d has no keyframes", path, i)
+		}
+		source.Path.sort()
+	}
+	scene.Listener.sort()
+	if a := scene.atmosphere(); a.kelvin() <= 0 || a.relativeHumidity < 0 || a.relativeHumidity > 100 || a.pressure <= 0 {
+		return nil, fmt.Errorf("%s: atmosphere at %v °C, %v%% humidity and %v kPa is out of range", path, a.temperature, a.relativeHumidity, a.pressure)
+	}
+	scene.dir = filepath.Dir(path)
+	return &scene, nil
+}
+
+// walls returns the walls of the scene with their materials resolved.
+func (s *sceneFile) walls() ([]Wall, error) {
+	walls := make([]Wall, len(s.Walls))
+	for i, spec := range s.Walls {
+		properties, ok := builtinMaterials[spec.Material]
+		if material, defined := s.Materials[spec.Material]; defined {
+			properties, ok = WallProperties{
+				absorption:            material.Absorption,
+				transparency:          material.Transparency,
+				transmissionRoughness: material.TransmissionRoughness,
+				roughness:             material.Roughness,
+			}, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("wall %d: unknown material %q", i, spec.Material)
+		}
+		walls[i] = Wall{
+			start:      Vector{spec.Start[0], spec.Start[1]},
+			end:        Vector{spec.End[0], spec.End[1]},
+			properties: properties,
+		}
+	}
+	return walls, nil
+}
+
+// atmosphere returns the standard atmosphere with the fields the scene sets
+// overridden.
+func (s *sceneFile) atmosphere() Atmosphere {
+	a := standardAtmosphere()
+	if s.Atmosphere == nil {
+		return a
+	}
+	if s.Atmosphere.Temperature != nil {
+		a.temperature = *s.Atmosphere.Temperature
+	}
+	if s.Atmosphere.RelativeHumidity != nil {
+		a.relativeHumidity = *s.Atmosphere.RelativeHumidity
+	}
+	if s.Atmosphere.Pressure != nil {
+		a.pressure = *s.Atmosphere.Pressure
+	}
+	return a
+}
+
+// at returns the position on the trajectory at time t. Before the first and
+// after the last keyframe it stays put.
+func (tr trajectory) at(t float64) Vector {
+	i := sort.Search(len(tr), func(i int) bool { return tr[i].Time > t })
+	if i == 0 {
+		return Vector{tr[0].Position[0], tr[0].Position[1]}
+	}
+	if i == len(tr) {
+		return Vector{tr[i-1].Position[0], tr[i-1].Position[1]}
+	}
+
+	a, b := tr[i-1], tr[i]
+	f := (t - a.Time) / (b.Time - a.Time)
+	return Vector{
+		a.Position[0] + f*(b.Position[0]-a.Position[0]),
+		a.Position[1] + f*(b.Position[1]-a.Position[1]),
+	}
+}
+
+// velocity returns how fast the position on the trajectory changes at time t,
+// in metres per second. A keyframe takes the velocity of the segment after it.
+func (tr trajectory) velocity(t float64) Vector {
+	i := sort.Search(len(tr), func(i int) bool { return tr[i].Time > t })
+	if i == 0 || i == len(tr) {
+		return Vector{}
+	}
+
+	a, b := tr[i-1], tr[i]
+	duration := b.Time - a.Time
+	return Vector{(b.Position[0] - a.Position[0]) / duration, (b.Position[1] - a.Position[1]) / duration}
+}
+
+func (tr trajectory) sort() {
+	sort.SliceStable(tr, func(i, j int) bool { return tr[i].Time < tr[j].Time })
+}
+
+// end returns the time of the last keyframe.
+func (tr trajectory) end() float64 {
+	return tr[len(tr)-1].Time
+}
+
+// bounds returns the box around all walls and keyframes of the scene.
+func (s *sceneFile) bounds() aabb {
+	box := aabb{min: Vector{math.Inf(1), math.Inf(1)}, max: Vector{math.Inf(-1), math.Inf(-1)}}
+	extend := func(p [2]float64) {
+		box = box.union(segmentBounds(Vector{p[0], p[1]}, Vector{p[0], p[1]}))
+	}
+	for _, wall := range s.Walls {
+		extend(wall.Start)
+		extend(wall.End)
+	}
+	for _, k := range s.Listener {
+		extend(k.Position)
+	}
+	for _, source := range s.Sources {
+		for _, k := range source.Path {
+			extend(k.Position)
+		}
+	}
+	return box
+}
+
+// newGame sets up a Game for the scene with the sources and listener at their
+// starting positions, the sources' files opened, and the view fitted around
+// the scene.
+func (s *sceneFile) newGame(crossfade int) (*Game, error) {
+	walls, err := s.walls()
+	if err != nil {
+		return nil, err
+	}
+	g := &Game{
+		walls:            walls,
+		view:             fitView(s.bounds()),
+		atmosphere:       s.atmosphere(),
+		maxOrder:         maxReflectionOrder,
+		imageSourceOrder: imageSourceOrder,
+		crossfade:        crossfade,
+		draggedSource:    -1,
+	}
+	for _, spec := range s.Sources {
+		source := newSineSource(spec.Path.at(0))
+		source.enabled = !spec.Disabled
+		if spec.Gain != nil {
+			source.gain = *spec.Gain
+		}
+		if spec.Frequency != 0 {
+			source.frequency = spec.Frequency
+		}
+		if spec.File != "" && spec.Signal != nil {
+			return nil, fmt.Errorf("source %d has both a file and a signal", len(g.audioSources))
+		}
+		if spec.Signal != nil {
+			if source.signal, err = spec.Signal.signal(); err != nil {
+				return nil, fmt.Errorf("source %d: %w", len(g.audioSources), err)
+			}
+			source.generator = spec.Signal.Type
+		}
+		if spec.File != "" {
+			source.generator = ""
+			path := spec.File
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(s.dir, path)
+			}
+			offset := time.Duration(spec.Offset * float64(time.Second))
+			if source.signal, err = newFileSignal(path, spec.Loop, 1, offset); err != nil {
+				return nil, err
+			}
+		}
+		g.addSource(source)
+	}
+	g.listener = Listener{heading: -math.Pi / 2, headRadius: headRadius}
+	g.moveListener(s.Listener.at(0))
+	g.getWallEdges()
+	g.buildSceneIndex()
+	return g, nil
+}
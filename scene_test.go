@@ -0,0 +1,120 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadScene(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scene.json")
+	data := `{
+		"materials": {"felt": {"absorption": [0.5, 0.5, 0.5, 0.5, 0.5, 0.5, 0.5], "roughness": 0.9}},
+		"walls": [
+			{"start": [0, 0], "end": [10, 0], "material": "felt"},
+			{"start": [10, 0], "end": [10, 5], "material": "plaster"}
+		],
+		"source": [{"time": 0, "position": [1, 1]}],
+		"listener": [{"time": 4, "position": [8, 2]}, {"time": 2, "position": [4, 2]}]
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	scene, err := loadScene(path)
+	if err != nil {
+		t.Fatalf("loadScene() error = %v", err)
+	}
+	walls, err := scene.walls()
+	if err != nil {
+		t.Fatalf("walls() error = %v", err)
+	}
+	if walls[0].properties.absorption != uniformBands(0.5) || walls[0].properties.roughness != 0.9 {
+		t.Errorf("felt wall has properties %+v", walls[0].properties)
+	}
+	if walls[1].properties != plasterWall {
+		t.Errorf("plaster wall has properties %+v, want the built-in plaster", walls[1].properties)
+	}
+	if scene.atmosphere() != standardAtmosphere() {
+		t.Errorf("atmosphere() = %+v, want the standard atmosphere", scene.atmosphere())
+	}
+
+	// Keyframes are sorted by time, and the position holds before the first
+	// and after the last one
+	tests := []struct {
+		time float64
+		want Vector
+	}{
+		{0, Vector{4, 2}},
+		{2, Vector{4, 2}},
+		{3, Vector{6, 2}},
+		{4, Vector{8, 2}},
+		{9, Vector{8, 2}},
+	}
+	for _, tt := range tests {
+		if got := scene.Listener.at(tt.time); got != tt.want {
+			t.Errorf("listener at %v s = %v, want %v", tt.time, got, tt.want)
+		}
+	}
+
+	scene.Walls[0].Material = "velvet"
+	if _, err := scene.walls(); err == nil {
+		t.Errorf("walls() with an unknown material returned no error")
+	}
+}
+
+func TestLoadSceneAtmosphere(t *testing.T) {
+	tests := []struct {
+		name       string
+		atmosphere string
+		want       Atmosphere
+		wantErr    bool
+	}{
+		{name: "absent", want: standardAtmosphere()},
+		{name: "temperature only", atmosphere: `{"temperature": 5}`, want: Atmosphere{temperature: 5, relativeHumidity: 50, pressure: referencePressure}},
+		{name: "freezing", atmosphere: `{"temperature": 0, "relativeHumidity": 80}`, want: Atmosphere{temperature: 0, relativeHumidity: 80, pressure: referencePressure}},
+		{name: "complete", atmosphere: `{"temperature": 30, "relativeHumidity": 20, "pressure": 90}`, want: Atmosphere{temperature: 30, relativeHumidity: 20, pressure: 90}},
+		{name: "humidity above 100", atmosphere: `{"relativeHumidity": 120}`, wantErr: true},
+		{name: "negative humidity", atmosphere: `{"relativeHumidity": -1}`, wantErr: true},
+		{name: "zero pressure", atmosphere: `{"pressure": 0}`, wantErr: true},
+		{name: "below absolute zero", atmosphere: `{"temperature": -300}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atmosphere := ""
+			if tt.atmosphere != "" {
+				atmosphere = `"atmosphere": ` + tt.atmosphere + `,`
+			}
+			path := filepath.Join(t.TempDir(), "scene.json")
+			data := `{` + atmosphere + `
+				"source": [{"time": 0, "position": [1, 1]}],
+				"listener": [{"time": 0, "position": [4, 2]}]
+			}`
+			if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			scene, err := loadScene(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("loadScene() accepted the atmosphere %s", tt.atmosphere)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadScene() error = %v", err)
+			}
+			got := scene.atmosphere()
+			if got != tt.want {
+				t.Errorf("atmosphere() = %+v, want %+v", got, tt.want)
+			}
+			for b, alpha := range got.absorptionCoefficients() {
+				if math.IsNaN(alpha) || alpha < 0 {
+					t.Errorf("band %d: absorption coefficient %v", b, alpha)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "math"
+
+// signal is a dry mono input stream at sampleRate.
+type signal interface {
+	// read fills out with the next samples of the stream.
+	read(out []float64)
+}
+
+// sineSignal is a pure tone.
+type sineSignal struct {
+	frequency, amplitude float64
+	phase                float64
+}
+
+func (s *sineSignal) read(out []float64) {
+	step := 2 * math.Pi * s.frequency / sampleRate
+	for i := range out {
+		out[i] = s.amplitude * math.Sin(s.phase)
+		s.phase = math.Mod(s.phase+step, 2*math.Pi)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import "io"
+
+// Distance in metres within which a click picks up a source.
+const sourcePickRadius = 0.4
+
+// addSource adds a source to the scene and returns its index. Its renderer is
+// created by the next prepareRenderers.
+func (g *Game) addSource(source AudioSource) int {
+	g.audioSources = append(g.audioSources, source)
+	g.renderers = append(g.renderers, nil)
+	return len(g.audioSources) - 1
+}
+
+// removeSource takes a source out of the scene and the mix.
+func (g *Game) removeSource(index int) {
+	g.prepareRenderers()
+	removed := g.audioSources[index].signal
+	g.audioSources = append(g.audioSources[:index], g.audioSources[index+1:]...)
+	g.renderers = append(g.renderers[:index], g.renderers[index+1:]...)
+	if g.selectedSource >= len(g.audioSources) {
+		g.selectedSource = len(g.audioSources) - 1
+	}
+	g.updateMixer()
+	g.retireSignal(removed)
+}
+
+// prepareRenderers creates a convolver for every source that doesn't have one
+// yet, playing the source's dry signal.
+func (g *Game) prepareRenderers() {
+	for len(g.renderers) < len(g.audioSources) {
+		g.renderers = append(g.renderers, nil)
+	}
+	for i, source := range g.audioSources {
+		if g.renderers[i] == nil {
+			g.renderers[i] = newConvolver(source.dry(), g.crossfade)
+		}
+	}
+	if g.mixer == nil {
+		g.mixer = newMixer()
+	}
+}
+
+// updateMixer hands the mixer the renderers of the enabled sources.
+func (g *Game) updateMixer() {
+	var channels []mixerChannel
+	for i, source := range g.audioSources {
+		if source.enabled {
+			channels = append(channels, mixerChannel{renderer: g.renderers[i], gain: source.gain})
+		}
+	}
+	g.mixer.setChannels(channels)
+}
+
+// sourceNear returns the index of the source closest to position within
+// sourcePickRadius, or -1 if there is none.
+func (g *Game) sourceNear(position Vector) int {
+	closest, closestDistance := -1, sourcePickRadius
+	for i, source := range g.audioSources {
+		if d := distance(source.position, position); d < closestDistance {
+			closest, closestDistance = i, d
+		}
+	}
+	return closest
+}
+
+// newSineSource returns an enabled source at position playing the default
+// tone.
+func newSineSource(position Vector) AudioSource {
+	return AudioSource{position: position, frequency: sineFreq, amplitude: defaultLevel, generator: "sine", gain: 1, enabled: true}
+}
+
+// setSignal makes a source play a new dry signal. Its renderer is replaced,
+// and picks up the source's impulse response at the next simulate.
+func (g *Game) setSignal(index int, dry signal, generator string) {
+	old := g.audioSources[index].signal
+	g.audioSources[index].signal = dry
+	g.audioSources[index].generator = generator
+	g.renderers[index] = nil
+	g.prepareRenderers()
+	g.updateMixer()
+	g.retireSignal(old)
+}
+
+// retireSignal has the mixer close a signal that holds a file once it has
+// rendered its last block from it. The signal must already be out of the mix.
+func (g *Game) retireSignal(old signal) {
+	if closer, ok := old.(io.Closer); ok {
+		g.mixer.retire(closer)
+	}
+}
+
+// cycleGenerator switches a source to the next built-in signal, with default
+// parameters.
+func (g *Game) cycleGenerator(index int) error {
+	next := 0
+	for i, generator := range generatorTypes {
+		if generator == g.audioSources[index].generator {
+			next = (i + 1) % len(generatorTypes)
+		}
+	}
+	dry, err := generatorSpec{Type: generatorTypes[next]}.signal()
+	if err != nil {
+		return err
+	}
+	g.setSignal(index, dry, generatorTypes[next])
+	return nil
+}
+
+// toggleSource switches a source on or off.
+func (g *Game) toggleSource(index int) {
+	g.audioSources[index].enabled = !g.audioSources[index].enabled
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"math"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMixerSumsEnabledSourcesByGain(t *testing.T) {
+	g := &Game{}
+	g.addSource(AudioSource{signal: constantSignal(1), gain: 0.5, enabled: true})
+	g.addSource(AudioSource{signal: constantSignal(1), gain: 2, enabled: true})
+	g.addSource(AudioSource{signal: constantSignal(1), gain: 4})
+	g.prepareRenderers()
+	for _, r := range g.renderers {
+		r.setImpulseResponse(impulseResponse{left: []float64{1}, right: []float64{0.5}})
+	}
+	g.updateMixer()
+
+	left, right := make([]float64, convolutionBlockSize), make([]float64, convolutionBlockSize)
+	g.mixer.render(left, right)
+	if math.Abs(left[0]-2.5) > 1e-9 || math.Abs(right[0]-1.25) > 1e-9 {
+		t.Errorf("mix = %v, %v, want 2.5, 1.25", left[0], right[0])
+	}
+
+	g.removeSource(0)
+	g.toggleSource(1)
+	g.updateMixer()
+	g.mixer.render(left, right)
+	if math.Abs(left[0]-6) > 1e-9 || math.Abs(right[0]-3) > 1e-9 {
+		t.Errorf("mix after removing and toggling = %v, %v, want 6, 3", left[0], right[0])
+	}
+}
+
+func TestPathsAreKeptPerSource(t *testing.T) {
+	g := rectangularRoom(10, 6, WallProperties{})
+	g.audioSources = []AudioSource{{position: Vector{2, 5}}, {position: Vector{8, 5}}}
+	g.listener = Listener{position: Vector{5, 5}, heading: -math.Pi / 2}
+	g.listener.placeEars()
+	g.addImageSourcePaths(0)
+	g.addImageSourcePaths(1)
+
+	for source, position := range []Vector{{2, 5}, {8, 5}} {
+		paths := pathsFrom(g.leftPaths, source)
+		if len(paths) != 1 {
+			t.Fatalf("source %d: %d paths, want 1", source, len(paths))
+		}
+		want := distance(position, g.listener.leftEar) / g.atmosphere.speedOfSound()
+		if math.Abs(paths[0].delay-want) > 1e-9 {
+			t.Errorf("source %d: delay %v, want %v", source, paths[0].delay, want)
+		}
+	}
+}
+
+// closingSignal is a steady level that records whether it was closed, and
+// whether it was read after that.
+type closingSignal struct {
+	closed, readClosed atomic.Bool
+}
+
+func (s *closingSignal) read(out []float64) {
+	if s.closed.Load() {
+		s.readClosed.Store(true)
+	}
+	for i := range out {
+		out[i] = 1
+	}
+}
+
+func (s *closingSignal) Close() error {
+	s.closed.Store(true)
+	return nil
+}
+
+func TestDroppedSignalsCloseAfterTheNextBlock(t *testing.T) {
+	tests := []struct {
+		name string
+		drop func(g *Game) error
+	}{
+		{name: "cycle generator", drop: func(g *Game) error { return g.cycleGenerator(0) }},
+		{name: "remove source", drop: func(g *Game) error { g.removeSource(0); return nil }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dropped, kept := &closingSignal{}, &closingSignal{}
+			g := &Game{}
+			g.addSource(AudioSource{signal: dropped, enabled: true})
+			g.addSource(AudioSource{signal: kept, enabled: true})
+			g.prepareRenderers()
+			g.updateMixer()
+			left, right := make([]float64, convolutionBlockSize), make([]float64, convolutionBlockSize)
+			g.mixer.render(left, right)
+
+			if err := tt.drop(g); err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			// The renderer may still be inside the old mix
+			if dropped.closed.Load() {
+				t.Errorf("signal closed before the mixer rendered another block")
+			}
+			g.mixer.render(left, right)
+			if !dropped.closed.Load() {
+				t.Errorf("signal still open after the next block")
+			}
+			if kept.closed.Load() {
+				t.Errorf("the other source's signal was closed")
+			}
+		})
+	}
+}
+
+func TestSwappedSignalsAreNotReadAfterClosing(t *testing.T) {
+	g := &Game{}
+	g.addSource(AudioSource{signal: &closingSignal{}, enabled: true})
+	g.prepareRenderers()
+	g.updateMixer()
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		left, right := make([]float64, convolutionBlockSize), make([]float64, convolutionBlockSize)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				g.mixer.render(left, right)
+			}
+		}
+	}()
+
+	var signals []*closingSignal
+	for i := 0; i < 200; i++ {
+		signal := &closingSignal{}
+		signals = append(signals, signal)
+		g.setSignal(0, signal, "")
+	}
+	close(stop)
+	<-done
+	g.setSignal(0, &closingSignal{}, "")
+	g.mixer.render(make([]float64, convolutionBlockSize), make([]float64, convolutionBlockSize))
+
+	for i, signal := range signals {
+		if !signal.closed.Load() {
+			t.Errorf("signal %d still open", i)
+		}
+		if signal.readClosed.Load() {
+			t.Errorf("signal %d read after it was closed", i)
+		}
+	}
+}
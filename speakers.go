@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// speakerLayout is a ring of loudspeakers around the listener, one per output
+// channel. Angles are azimuths in degrees, anticlockwise from straight ahead.
+// An LFE channel has no direction and gets no panned sound.
+type speakerLayout struct {
+	angles []float64
+	lfe    int    // channel of the LFE speaker, or -1
+	mask   uint32 // WAV channel mask naming the speaker of each channel
+}
+
+// WAV channel mask bits.
+const (
+	speakerFrontLeft   = 0x1
+	speakerFrontRight  = 0x2
+	speakerFrontCentre = 0x4
+	speakerLFE         = 0x8
+	speakerBackLeft    = 0x10
+	speakerBackRight   = 0x20
+	speakerSideLeft    = 0x200
+	speakerSideRight   = 0x400
+)
+
+// speakerLayouts are the named layouts, in the channel order of WAV files.
+var speakerLayouts = map[string]speakerLayout{
+	"stereo": {angles: []float64{30, -30}, lfe: -1,
+		mask: speakerFrontLeft | speakerFrontRight},
+	"quad": {angles: []float64{45, -45, 135, -135}, lfe: -1,
+		mask: speakerFrontLeft | speakerFrontRight | speakerBackLeft | speakerBackRight},
+	"5.1": {angles: []float64{30, -30, 0, 0, 110, -110}, lfe: 3,
+		mask: speakerFrontLeft | speakerFrontRight | speakerFrontCentre | speakerLFE | speakerSideLeft | speakerSideRight},
+	"7.1": {angles: []float64{30, -30, 0, 0, 135, -135, 90, -90}, lfe: 3,
+		mask: speakerFrontLeft | speakerFrontRight | speakerFrontCentre | speakerLFE | speakerBackLeft | speakerBackRight | speakerSideLeft | speakerSideRight},
+}
+
+// parseSpeakerLayout returns a named layout, or a custom one given as a
+// comma-separated list of angles in degrees such as "30,-30,0,110,-110".
+func parseSpeakerLayout(text string) (*speakerLayout, error) {
+	if layout, ok := speakerLayouts[text]; ok {
+		return &layout, nil
+	}
+
+	layout := &speakerLayout{lfe: -1}
+	for _, field := range strings.Split(text, ",") {
+		angle, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("speaker layout %q is neither stereo, quad, 5.1, 7.1 nor a list of angles", text)
+		}
+		layout.angles = append(layout.angles, angle)
+	}
+	return layout, nil
+}
+
+// channels returns the number of output channels.
+func (l *speakerLayout) channels() int {
+	return len(l.angles)
+}
+
+// pan returns the gain of each speaker for sound arriving from azimuth, in
+// degrees, by two-dimensional vector-base amplitude panning (Pulkki, 1997).
+// The sound is placed on the pair of adjacent speakers either side of it with
+// gains whose squares add up to one. Where adjacent speakers are half a circle
+// or more apart, as behind a stereo pair, the nearer one plays it alone.
+func (l *speakerLayout) pan(azimuth float64) []float64 {
+	gains := make([]float64, len(l.angles))
+	var ring []int
+	for ch := range l.angles {
+		if ch != l.lfe {
+			ring = append(ring, ch)
+		}
+	}
+	if len(ring) == 0 {
+		return gains
+	}
+	if len(ring) == 1 {
+		gains[ring[0]] = 1
+		return gains
+	}
+	sort.Slice(ring, func(i, j int) bool { return wrapDegrees(l.angles[ring[i]]) < wrapDegrees(l.angles[ring[j]]) })
+
+	for i, first := range ring {
+		second := ring[(i+1)%len(ring)]
+		// Angles from the first speaker anticlockwise to the source and to
+		// the second speaker
+		offset := wrapDegrees(azimuth - l.angles[first])
+		span := wrapDegrees(l.angles[second] - l.angles[first])
+		if span == 0 && len(ring) == 2 {
+			span = 360
+		}
+		if span == 0 || offset > span {
+			continue
+		}
+
+		if span >= 180 {
+			if offset < span/2 {
+				gains[first] = 1
+			} else {
+				gains[second] = 1
+			}
+			return gains
+		}
+
+		// Solve p = g1·l1 + g2·l2 with the first speaker along the x axis
+		sinOffset, cosOffset := math.Sincos(offset * math.Pi / 180)
+		sinSpan, cosSpan := math.Sincos(span * math.Pi / 180)
+		g2 := sinOffset / sinSpan
+		g1 := cosOffset - g2*cosSpan
+		norm := math.Hypot(g1, g2)
+		gains[first] = g1 / norm
+		gains[second] = g2 / norm
+		return gains
+	}
+	return gains
+}
+
+// wrapDegrees returns angle wrapped to [0°, 360°).
+func wrapDegrees(angle float64) float64 {
+	return math.Mod(math.Mod(angle, 360)+360, 360)
+}
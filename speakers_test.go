@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestVBAPGains(t *testing.T) {
+	tests := []struct {
+		layout  string
+		azimuth float64
+		want    []float64
+	}{
+		{"stereo", 0, []float64{math.Sqrt2 / 2, math.Sqrt2 / 2}},
+		{"stereo", 30, []float64{1, 0}},
+		{"stereo", -30, []float64{0, 1}},
+		{"stereo", 100, []float64{1, 0}}, // outside the pair, to the nearer speaker
+		{"stereo", -170, []float64{0, 1}},
+		{"quad", 90, []float64{math.Sqrt2 / 2, 0, math.Sqrt2 / 2, 0}},
+		{"quad", 180, []float64{0, 0, math.Sqrt2 / 2, math.Sqrt2 / 2}},
+		{"5.1", 0, []float64{0, 0, 1, 0, 0, 0}},
+		{"7.1", -90, []float64{0, 0, 0, 0, 0, 0, 0, 1}},
+		{"0,120,240", 60, []float64{math.Sqrt2 / 2, math.Sqrt2 / 2, 0}},
+		{"45", 170, []float64{1}},
+	}
+
+	for _, tt := range tests {
+		layout, err := parseSpeakerLayout(tt.layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := layout.pan(tt.azimuth)
+		for ch := range tt.want {
+			if math.Abs(got[ch]-tt.want[ch]) > 1e-9 {
+				t.Errorf("%s at %v° = %v, want %v", tt.layout, tt.azimuth, got, tt.want)
+				break
+			}
+		}
+	}
+
+	if _, err := parseSpeakerLayout("30,left"); err == nil {
+		t.Error("parseSpeakerLayout() with a bad angle returned no error")
+	}
+}
+
+func TestVBAPPointsAtTheSource(t *testing.T) {
+	layout, _ := parseSpeakerLayout("7.1")
+	for azimuth := -180.0; azimuth < 180; azimuth += 7 {
+		gains := layout.pan(azimuth)
+
+		// The gain vector of the active pair points back at the source, with
+		// unit power
+		var x, y, power float64
+		for ch, gain := range gains {
+			sin, cos := math.Sincos(layout.angles[ch] * math.Pi / 180)
+			x, y = x+gain*cos, y+gain*sin
+			power += gain * gain
+		}
+		if gains[layout.lfe] != 0 || math.Abs(power-1) > 1e-9 {
+			t.Errorf("gains at %v° = %v, want unit power and a silent LFE", azimuth, gains)
+		}
+		if got := math.Atan2(y, x) * 180 / math.Pi; math.Abs(math.Remainder(got-azimuth, 360)) > 1e-6 {
+			t.Errorf("gains at %v° point at %v°", azimuth, got)
+		}
+	}
+}
@@ -1,76 +1,139 @@
-package main
-
-import (
-	"github.com/hajimehoshi/oto/v2"
-)
-
-type Vector struct {
-	x, y float64
-}
-
-
-
-type Ray struct {
-	origin, direction Vector
-}
-
-type WallProperties struct {
-	absorption   float64
-	transparency float64
-	transmissionRoughness float64
-	roughness   float64
-}
-
-type Wall struct {
-	start, end Vector
-	properties WallProperties
-}
-
-type AudioSource struct {
-	position  Vector
-	frequency float64
-	amplitude float64
-}
-
-type Listener struct {
-	position Vector
-	leftEar  Vector
-	rightEar Vector
-}
-
-type AudioPath struct {
-	source    AudioSource
-	delay     float64
-	amplitude float64
-	direction Vector
-	ild       float64
-}
-
-type Game struct {
-	walls         []Wall
-	wallEdges     []WallEdge
-    audioSource   AudioSource
-    listener      Listener
-    rays          []Ray
-    leftPaths     []AudioPath
-    rightPaths    []AudioPath
-    audioContext  *oto.Context
-    player        oto.Player
-    buffer        []byte
-    totalSamples  int
-    frame         int
-    rayPathPoints      [][]RayPathPoint 
-	isDragging    bool
-}
-
-type RayPathPoint struct {
-    position  Vector
-    intensity float64
-}
-
-type WallEdge struct {
-    position Vector
-    normal1  Vector  // Normal of first wall
-    normal2  Vector  // Normal of second wall (if corner)
-    isCorner bool
-}
\ No newline at end of file
+package main
+
+import (
+	"github.com/hajimehoshi/oto/v2"
+)
+
+type Vector struct {
+	x, y float64
+}
+
+type Ray struct {
+	origin, direction Vector
+}
+
+type WallProperties struct {
+	absorption            bands
+	transparency          bands
+	transmissionRoughness float64
+	roughness             float64
+}
+
+type Wall struct {
+	start, end Vector
+	properties WallProperties
+}
+
+// AudioSource is a point source. Its dry signal is signal, or a sine at
+// frequency with the given amplitude when signal is nil, and it is mixed in
+// with gain while enabled. velocity is in metres per second.
+type AudioSource struct {
+	position  Vector
+	velocity  Vector
+	frequency float64
+	amplitude float64
+	signal    signal
+	generator string // type of the built-in signal playing, "" for a file
+	gain      float64
+	enabled   bool
+}
+
+// dry returns the signal the source plays.
+func (s AudioSource) dry() signal {
+	if s.signal != nil {
+		return s.signal
+	}
+	return &sineSignal{frequency: s.frequency, amplitude: s.amplitude}
+}
+
+// Listener is a head at position facing along heading, an angle in radians
+// measured like atan2 in scene coordinates. The ears sit headRadius either side
+// of the centre and are derived from the other fields by placeEars. velocity
+// is in metres per second.
+type Listener struct {
+	position   Vector
+	velocity   Vector
+	heading    float64
+	headRadius float64
+	leftEar    Vector
+	rightEar   Vector
+}
+
+// View maps scene coordinates, which are in metres, to screen pixels. offset
+// is where the scene origin appears on screen.
+type View struct {
+	pixelsPerMeter float64
+	offset         Vector
+}
+
+// Atmosphere describes the air the sound travels through. temperature is in
+// degrees Celsius, relativeHumidity in percent and pressure in kPa.
+type Atmosphere struct {
+	temperature      float64
+	relativeHumidity float64
+	pressure         float64
+}
+
+// AudioPath is one way sound gets from the source to an ear. delay is in
+// seconds and energy holds the energy per octave band relative to the
+// direct sound at referenceDistance. direction points from the listener
+// towards where the sound arrives from. delayRate is how fast delay changes as
+// the source and listener move.
+type AudioPath struct {
+	source    int // index into Game.audioSources
+	delay     float64
+	delayRate float64
+	energy    bands
+	direction Vector
+	imageKey  int  // 1 + index of the image source the path comes from, 0 for other paths
+	doppler   bool // rendered through a moving delay tap instead of the impulse response
+}
+
+type Game struct {
+	walls            []Wall
+	wallEdges        []WallEdge
+	wallIndex        *bvh
+	audioSources     []AudioSource
+	selectedSource   int
+	draggedSource    int // index of the source being dragged, or -1
+	listener         Listener
+	view             View
+	atmosphere       Atmosphere
+	airAbsorption    bands
+	rays             []Ray
+	maxOrder         int
+	engine           propagationEngine
+	imageSourceOrder int
+	pendingRays      []rayTask
+	leftPaths        []AudioPath
+	rightPaths       []AudioPath
+	responses        [][][]float64 // per source and output channel, empty while disabled
+	hrtf             *hrtf
+	ambisonics       *ambisonics    // Ambisonic instead of binaural output if set
+	speakers         *speakerLayout // loudspeaker instead of binaural output if set
+	audioContext     *oto.Context
+	player           oto.Player
+	renderers        []*convolver // per source, created by prepareRenderers
+	mixer            *mixer
+	master           *masterBus
+	crossfade        int
+	doppler          bool // render the strongest image-source paths with Doppler shift
+	stream           *audioStream
+	frame            int
+	rayPathPoints    [][]RayPathPoint
+	isDragging       bool
+}
+
+type RayPathPoint struct {
+	position  Vector
+	intensity float64
+}
+
+type WallEdge struct {
+	position Vector
+	normal1  Vector // Normal of first wall
+	normal2  Vector // Normal of second wall (if corner)
+	wall1    int    // Index of first wall
+	wall2    int    // Index of second wall (if corner), -1 otherwise
+	isCorner bool
+}
@@ -1,87 +1,200 @@
-package main
-
-import (
-	"math"
-)
-
-
-// Add adds two vectors and returns the result.
-func (v Vector) add(other Vector) Vector {
-	return Vector{
-		x: v.x + other.x,
-		y: v.y + other.y,
-	}
-}
-
-
-func (g *Game) traceRay(ray Ray, intensity float64, bounces int, rayIndex int) {
-	if bounces == 0 || intensity < 0.01 {
-		return
-	}
-
-	closestIntersection := Vector{math.Inf(1), math.Inf(1)}
-	closestWall := -1
-	minDist := math.Inf(1)
-	lastIntersection := g.rayPathPoints[rayIndex][len(g.rayPathPoints[rayIndex])-1].position
-
-	for i, wall := range g.walls {
-		intersection := rayWallIntersection(ray, wall, lastIntersection)
-		if intersection.x != math.Inf(1) && intersection.y != math.Inf(1) {
-			dist := distance(ray.origin, intersection)
-			if dist < minDist {
-				minDist = dist
-				closestIntersection = intersection
-				closestWall = i
-			}
-		}
-	}
-
-	perpendicularDist, distanceToSource := distanceFromPointToLine(ray, g.audioSource.position)
-	if perpendicularDist < proximityThreshold && distanceToSource != -1 && distanceToSource < minDist {
-		g.addAudioPaths(ray, intensity)
-	} else if closestWall == -1 {
-		edgeIntersection := extendRayToScreenEdge(ray)
-		g.rayPathPoints[rayIndex] = append(g.rayPathPoints[rayIndex], RayPathPoint{edgeIntersection, intensity})
-		return
-	}
-
-	g.rayPathPoints[rayIndex] = append(g.rayPathPoints[rayIndex], RayPathPoint{closestIntersection, intensity})
-	distanceOriginIntersection := distance(ray.origin, closestIntersection)
-	intensity = intensity / (1 + distanceOriginIntersection*distanceOriginIntersection/10000)
-	wall := g.walls[closestWall]
-
-	for _, edge := range g.wallEdges {
-		if !edge.isCorner {
-			edgeDist := distance(closestIntersection, edge.position)
-			if edgeDist < 10.0 {
-				g.handleDiffraction(ray, wall, edge, closestIntersection, intensity, bounces)
-				return
-			}
-		}
-	}
-
-	reflectedIntensity := intensity * (1.0 - wall.properties.transparency) * (1.0 - wall.properties.absorption)
-	if reflectedIntensity > 0.01 {
-		wallDirection := Vector{wall.end.x - wall.start.x, wall.end.y - wall.start.y}
-		wallNormal := Vector{-wallDirection.y, wallDirection.x}.normalize()
-
-		// Reflect the ray and add randomness
-		reflectedDirection := reflect(ray.direction, wallNormal)
-		reflectedRay := Ray{closestIntersection, reflectedDirection}
-
-		newRayIndex := len(g.rayPathPoints)
-		g.rayPathPoints = append(g.rayPathPoints, []RayPathPoint{{closestIntersection, reflectedIntensity}})
-		g.traceRay(reflectedRay, reflectedIntensity, bounces-1, newRayIndex)
-	}
-
-	transmittedIntensity := intensity * wall.properties.transparency
-	if transmittedIntensity > 0.01 {
-		transmittedDirection := ray.direction
-		transmittedRay := Ray{closestIntersection, transmittedDirection}
-
-		newRayIndex := len(g.rayPathPoints)
-		g.rayPathPoints = append(g.rayPathPoints, []RayPathPoint{{closestIntersection, transmittedIntensity}})
-		g.traceRay(transmittedRay, transmittedIntensity, bounces-1, newRayIndex)
-	}
-}
-
+package main
+
+import (
+	"math/rand"
+)
+
+// Add adds two vectors and returns the result.
+func (v Vector) add(other Vector) Vector {
+	return Vector{
+		x: v.x + other.x,
+		y: v.y + other.y,
+	}
+}
+
+const (
+	// Below this peak band energy rays enter Russian roulette instead of being
+	// traced unconditionally.
+	energyThreshold = 0.01
+	// Up to this order every branch leaving a wall hit is followed; deeper
+	// rays continue along a single randomly chosen branch.
+	splitOrders = 2
+	// Rays of this order and deeper are traced but not recorded in
+	// g.rayPathPoints.
+	maxDrawnOrder = 4
+)
+
+// rayTask is a ray waiting on the tracer's stack.
+type rayTask struct {
+	ray       Ray
+	intensity bands
+	order     int     // number of wall interactions before this ray
+	travelled float64 // path length from the source to the ray's origin
+	pathIndex int     // entry in g.rayPathPoints, or -1 if the ray isn't drawn
+	// Every interaction so far was a specular reflection or a straight
+	// transmission, as image sources deliver them
+	specular    bool
+	reflections int // specular reflections so far
+	source      int // index of the source that emitted the ray
+}
+
+// rayBranch is one of the rays leaving a wall interaction.
+type rayBranch struct {
+	ray       Ray
+	intensity bands
+	specular  bool
+	reflected bool // off the wall rather than through it
+}
+
+// traceRays follows every queued ray, and every ray spawned from those, until
+// they leave the scene, lose the roulette or exceed g.maxOrder. It works off
+// an explicit stack so the reflection order isn't bounded by recursion depth.
+func (g *Game) traceRays() {
+	for len(g.pendingRays) > 0 {
+		task := g.pendingRays[len(g.pendingRays)-1]
+		g.pendingRays = g.pendingRays[:len(g.pendingRays)-1]
+		g.traceRay(task)
+	}
+}
+
+func (g *Game) traceRay(task rayTask) {
+	ray, intensity := task.ray, task.intensity
+	if task.order >= g.maxOrder {
+		return
+	}
+
+	// Russian roulette: weak rays survive with a probability proportional to
+	// their energy and are boosted to compensate, which keeps the estimate
+	// unbiased while letting the ray count die out
+	if peak := intensity.max(); peak < energyThreshold {
+		survival := peak / energyThreshold
+		if rand.Float64() >= survival {
+			return
+		}
+		intensity = intensity.scale(1 / survival)
+	}
+
+	closestIntersection, closestWall := g.closestWallHit(ray, ray.origin)
+	if closestWall == -1 {
+		edgeIntersection := extendRayToEdge(ray, g.view.bounds())
+		g.detectAtEars(task, intensity, distance(ray.origin, edgeIntersection))
+		g.recordPathPoint(task, edgeIntersection, intensity)
+		return
+	}
+
+	distanceOriginIntersection := distance(ray.origin, closestIntersection)
+	g.detectAtEars(task, intensity, distanceOriginIntersection)
+	g.recordPathPoint(task, closestIntersection, intensity)
+
+	// Geometric spreading is accounted for by the receivers, so along the
+	// segment the ray only loses energy to the air
+	intensity = intensity.mul(g.airAttenuation(distanceOriginIntersection))
+	task.travelled += distanceOriginIntersection
+	wall := g.walls[closestWall]
+
+	wallDirection := Vector{wall.end.x - wall.start.x, wall.end.y - wall.start.y}
+	wallNormal := Vector{-wallDirection.y, wallDirection.x}.normalize()
+
+	// The roughness acts as the scattering coefficient: that share of the
+	// reflected energy leaves in a Lambert-distributed diffuse ray, the rest
+	// is reflected specularly
+	reflectedIntensity := intensity.mul(wall.properties.transparency.complement()).mul(wall.properties.absorption.complement())
+	reflectedDirection := reflect(ray.direction, wallNormal)
+	diffuseDirection := lambertDirection(faceAgainst(wallNormal, ray.direction))
+
+	// Transmission works like reflection on the far side of the wall:
+	// transmissionRoughness spreads that share of the energy into a Lambert
+	// lobe, the rest passes straight through
+	transmittedIntensity := intensity.mul(wall.properties.transparency)
+	exitNormal := faceAgainst(wallNormal, Vector{-ray.direction.x, -ray.direction.y})
+
+	g.scatter(task, []rayBranch{
+		{Ray{closestIntersection, reflectedDirection}, reflectedIntensity.scale(1.0 - wall.properties.roughness), true, true},
+		{Ray{closestIntersection, diffuseDirection}, reflectedIntensity.scale(wall.properties.roughness), false, true},
+		{Ray{closestIntersection, ray.direction}, transmittedIntensity.scale(1.0 - wall.properties.transmissionRoughness), true, false},
+		{Ray{closestIntersection, lambertDirection(exitNormal)}, transmittedIntensity.scale(wall.properties.transmissionRoughness), false, false},
+	})
+}
+
+// coveredByImageSources reports whether the image-source engine already
+// delivers the path the ray has followed, so the tracer must not add it again.
+// Image sources pass straight through any number of walls, so only the
+// reflections count towards their order.
+func (g *Game) coveredByImageSources(task rayTask) bool {
+	return g.engine == engineHybrid && task.specular && task.reflections <= g.imageSourceOrder
+}
+
+// scatter queues the rays leaving the wall interaction that ended parent,
+// whose travelled length must already include the segment up to the wall. Up
+// to splitOrders every branch with energy left is followed. Beyond that a
+// single branch is picked with probability proportional to its mean energy
+// and reweighted by the inverse of that probability, so the ray count stays
+// constant per path while the expected energy is unchanged.
+func (g *Game) scatter(parent rayTask, branches []rayBranch) {
+	order := parent.order + 1
+	if order < splitOrders {
+		for _, branch := range branches {
+			if branch.intensity.max() > 0 {
+				g.spawnRay(parent.child(branch, branch.intensity))
+			}
+		}
+		return
+	}
+
+	total := 0.0
+	for _, branch := range branches {
+		total += branch.intensity.mean()
+	}
+	if total <= 0 {
+		return
+	}
+
+	pick := rand.Float64() * total
+	for _, branch := range branches {
+		weight := branch.intensity.mean()
+		if weight <= 0 {
+			continue
+		}
+		if pick < weight {
+			g.spawnRay(parent.child(branch, branch.intensity.scale(total/weight)))
+			return
+		}
+		pick -= weight
+	}
+}
+
+// child returns the ray that continues task along branch with the given
+// intensity.
+func (task rayTask) child(branch rayBranch, intensity bands) rayTask {
+	reflections := task.reflections
+	if branch.reflected {
+		reflections++
+	}
+	return rayTask{
+		ray:         branch.ray,
+		intensity:   intensity,
+		order:       task.order + 1,
+		travelled:   task.travelled,
+		specular:    task.specular && branch.specular,
+		reflections: reflections,
+		source:      task.source,
+	}
+}
+
+// spawnRay queues a ray for tracing, starting a new entry in g.rayPathPoints
+// at its origin if the ray is shallow enough to be drawn.
+func (g *Game) spawnRay(task rayTask) {
+	task.pathIndex = -1
+	if task.order < maxDrawnOrder {
+		task.pathIndex = len(g.rayPathPoints)
+		g.rayPathPoints = append(g.rayPathPoints, []RayPathPoint{{task.ray.origin, task.intensity.mean()}})
+	}
+	g.pendingRays = append(g.pendingRays, task)
+}
+
+func (g *Game) recordPathPoint(task rayTask, position Vector, intensity bands) {
+	if task.pathIndex == -1 {
+		return
+	}
+	g.rayPathPoints[task.pathIndex] = append(g.rayPathPoints[task.pathIndex], RayPathPoint{position, intensity.mean()})
+}
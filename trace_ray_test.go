@@ -1,57 +1,403 @@
-package main
-
-import (
-	"math"
-	"testing"
-)
-
-func TestDistanceFromPointToLine(t *testing.T) {
-	tests := []struct {
-		name     string
-		ray      Ray
-		point    Vector
-		wantDist float64
-		wantDistToSource float64
-	}{
-		{
-			name: "point on the line",
-			ray:  Ray{Vector{0, 0}, Vector{1, 0}},
-			point:    Vector{2, 0},
-			wantDist: 0,
-			wantDistToSource: 2,
-		},
-		{
-			name: "point above the line",
-			ray:  Ray{Vector{0, 0}, Vector{1, 0}},
-			point:    Vector{2, 1},
-			wantDist: 1,
-			wantDistToSource: 2,
-		},
-		{
-			name: "point below the line",
-			ray:  Ray{Vector{0, 0}, Vector{1, 0}},
-			point:    Vector{2, -1},
-			wantDist: 1,
-			wantDistToSource: 2,
-		},
-		{
-			name: "point away from the line",
-			ray:  Ray{Vector{50, 300}, Vector{-1, 1.2246467991473515e-16}},
-			point:    Vector{400, 300},
-			wantDist: 0,
-			wantDistToSource: -1,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			dist, distToSource := distanceFromPointToLine(tt.ray, tt.point)
-			if math.Abs(dist-tt.wantDist) > 1e-6 {
-				t.Errorf("distanceFromPointToLine() got dist = %v, want %v", dist, tt.wantDist)
-			}
-			if math.Abs(distToSource-tt.wantDistToSource) > 1e-6 {
-				t.Errorf("distanceFromPointToLine() got distToSource = %v, want %v", distToSource, tt.wantDistToSource)
-			}
-		})
-	}
-}
\ No newline at end of file
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDistanceFromPointToLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		ray      Ray
+		point    Vector
+		wantDist float64
+		wantDistToSource float64
+	}{
+		{
+			name: "point on the line",
+			ray:  Ray{Vector{0, 0}, Vector{1, 0}},
+			point:    Vector{2, 0},
+			wantDist: 0,
+			wantDistToSource: 2,
+		},
+		{
+			name: "point above the line",
+			ray:  Ray{Vector{0, 0}, Vector{1, 0}},
+			point:    Vector{2, 1},
+			wantDist: 1,
+			wantDistToSource: 2,
+		},
+		{
+			name: "point below the line",
+			ray:  Ray{Vector{0, 0}, Vector{1, 0}},
+			point:    Vector{2, -1},
+			wantDist: 1,
+			wantDistToSource: 2,
+		},
+		{
+			name: "point away from the line",
+			ray:  Ray{Vector{50, 300}, Vector{-1, 1.2246467991473515e-16}},
+			point:    Vector{400, 300},
+			wantDist: 0,
+			wantDistToSource: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dist, distToSource := distanceFromPointToLine(tt.ray, tt.point)
+			if math.Abs(dist-tt.wantDist) > 1e-6 {
+				t.Errorf("distanceFromPointToLine() got dist = %v, want %v", dist, tt.wantDist)
+			}
+			if math.Abs(distToSource-tt.wantDistToSource) > 1e-6 {
+				t.Errorf("distanceFromPointToLine() got distToSource = %v, want %v", distToSource, tt.wantDistToSource)
+			}
+		})
+	}
+}
+
+func TestReceiverEnergyFollowsSpreadingLoss(t *testing.T) {
+	tests := []struct {
+		name     string
+		receiver Vector
+	}{
+		{name: "near", receiver: Vector{6.5, 5}},
+		{name: "far", receiver: Vector{5, 9}},
+		{name: "diagonal", receiver: Vector{7.8, 7.8}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Game{
+				audioSources: []AudioSource{{position: Vector{5, 5}}},
+				listener:     Listener{leftEar: tt.receiver, rightEar: tt.receiver},
+				atmosphere:   Atmosphere{temperature: 20, relativeHumidity: 100, pressure: referencePressure},
+				view:         View{pixelsPerMeter: 100},
+				engine:       engineRayTracing,
+				maxOrder:     1,
+			}
+			g.buildSceneIndex()
+
+			for i := 0; i < numRays; i++ {
+				angle := float64(i) * 2 * math.Pi / float64(numRays)
+				g.spawnRay(rayTask{ray: Ray{g.audioSources[0].position, Vector{math.Cos(angle), math.Sin(angle)}}, intensity: uniformBands(1.0)})
+			}
+			g.traceRays()
+
+			got := 0.0
+			for _, path := range g.leftPaths {
+				got += path.energy[0]
+			}
+			want := spreadingLoss(distance(g.audioSources[0].position, tt.receiver))
+			if math.Abs(got-want)/want > 0.1 {
+				t.Errorf("received energy = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestScatterKeepsExpectedEnergy(t *testing.T) {
+	branches := []rayBranch{
+		{Ray{Vector{5, 5}, Vector{1, 0}}, bands{0.4, 0.35, 0.3, 0.3, 0.25, 0.2, 0.1}, true, true},
+		{Ray{Vector{5, 5}, Vector{0, 1}}, bands{0.1, 0.15, 0.2, 0.2, 0.25, 0.3, 0.4}, false, true},
+		{Ray{Vector{5, 5}, Vector{-1, 0}}, uniformBands(0.05), true, false},
+		{Ray{Vector{5, 5}, Vector{0, -1}}, bands{}, false, false},
+	}
+
+	// Below splitOrders every branch is followed, which is the reference
+	split := &Game{}
+	split.scatter(rayTask{order: 0}, branches)
+	var want bands
+	for _, task := range split.pendingRays {
+		for b, energy := range task.intensity {
+			want[b] += energy
+		}
+	}
+	if len(split.pendingRays) != 3 {
+		t.Errorf("split scatter queued %d rays, want 3", len(split.pendingRays))
+	}
+
+	const runs = 20000
+	var got bands
+	for i := 0; i < runs; i++ {
+		g := &Game{}
+		g.scatter(rayTask{order: splitOrders}, branches)
+		if len(g.pendingRays) != 1 {
+			t.Fatalf("scatter past splitOrders queued %d rays, want 1", len(g.pendingRays))
+		}
+		for b, energy := range g.pendingRays[0].intensity {
+			got[b] += energy / runs
+		}
+	}
+	for b := range want {
+		if math.Abs(got[b]-want[b]) > 0.03*want[b] {
+			t.Errorf("band %d: single-branch energy averages %v, splitting gives %v", b, got[b], want[b])
+		}
+	}
+}
+
+func TestRouletteRespectsEnergyThreshold(t *testing.T) {
+	receiver := Vector{7, 5}
+	trace := func(intensity bands) []AudioPath {
+		g := &Game{
+			audioSources: []AudioSource{{position: Vector{5, 5}}},
+			listener:     Listener{leftEar: receiver, rightEar: receiver},
+			atmosphere:   standardAtmosphere(),
+			view:         View{pixelsPerMeter: 100},
+			engine:       engineRayTracing,
+			maxOrder:     1,
+		}
+		g.buildSceneIndex()
+		g.spawnRay(rayTask{ray: Ray{Vector{5, 5}, Vector{1, 0}}, intensity: intensity})
+		g.traceRays()
+		return g.leftPaths
+	}
+	reference := trace(uniformBands(1.0))
+	if len(reference) != 1 {
+		t.Fatalf("ray through the receiver gave %d paths, want 1", len(reference))
+	}
+	perUnit := reference[0].energy[0]
+
+	tests := []struct {
+		name     string
+		peak     float64
+		survival float64
+	}{
+		{name: "at the threshold", peak: energyThreshold, survival: 1},
+		{name: "above the threshold", peak: 4 * energyThreshold, survival: 1},
+		{name: "quarter of the threshold", peak: energyThreshold / 4, survival: 0.25},
+		{name: "tenth of the threshold", peak: energyThreshold / 10, survival: 0.1},
+	}
+
+	const runs = 100000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Only the peak band decides the roulette
+			intensity := uniformBands(tt.peak / 2)
+			intensity[3] = tt.peak
+
+			survived, energy := 0, 0.0
+			for i := 0; i < runs; i++ {
+				paths := trace(intensity)
+				if len(paths) == 0 {
+					continue
+				}
+				survived++
+				energy += paths[0].energy[3] / runs
+				if boosted := paths[0].energy[3] / perUnit; math.Abs(boosted-math.Max(tt.peak, energyThreshold)) > 1e-9 {
+					t.Fatalf("surviving ray carries %v, want %v", boosted, math.Max(tt.peak, energyThreshold))
+				}
+			}
+
+			if rate := float64(survived) / runs; math.Abs(rate-tt.survival) > 0.02 {
+				t.Errorf("%v of the rays survived, want %v", rate, tt.survival)
+			}
+			if want := tt.peak * perUnit; math.Abs(energy-want) > 0.05*want {
+				t.Errorf("mean received energy %v, want %v", energy, want)
+			}
+		})
+	}
+}
+
+func TestTraceRaysStopsAtMaxOrder(t *testing.T) {
+	for _, maxOrder := range []int{1, 2, 3} {
+		g := rectangularRoom(10, 6, WallProperties{})
+		g.maxOrder = maxOrder
+		for i := 0; i < numRays; i++ {
+			angle := (float64(i) + 0.5) * 2 * math.Pi / float64(numRays)
+			g.spawnRay(rayTask{ray: Ray{Vector{3, 2}, Vector{math.Cos(angle), math.Sin(angle)}}, intensity: uniformBands(1.0)})
+		}
+		g.traceRays()
+
+		// A lossless specular room keeps one ray per emitted ray. Every ray
+		// below maxOrder is traced to a wall and records a second point; the
+		// rays queued at maxOrder are dropped with only their origin.
+		traced, dropped := 0, 0
+		for _, points := range g.rayPathPoints {
+			if len(points) > 1 {
+				traced++
+			} else {
+				dropped++
+			}
+		}
+		if traced != maxOrder*numRays || dropped != numRays {
+			t.Errorf("maxOrder %d: %d rays traced and %d dropped, want %d and %d", maxOrder, traced, dropped, maxOrder*numRays, numRays)
+		}
+	}
+}
+
+// bounceOffWall traces a ray of unit energy into a single wall along the x
+// axis, as the source emits it, and returns the rays queued to leave it.
+func bounceOffWall(properties WallProperties, ray Ray) []rayTask {
+	g := &Game{
+		walls:      []Wall{{Vector{0, 0}, Vector{10, 0}, properties}},
+		atmosphere: standardAtmosphere(),
+		view:       View{pixelsPerMeter: 100},
+		engine:     engineRayTracing,
+		maxOrder:   splitOrders,
+	}
+	g.buildSceneIndex()
+	g.traceRay(rayTask{ray: ray, intensity: uniformBands(1.0), pathIndex: -1, specular: true})
+	return g.pendingRays
+}
+
+func TestLambertDirectionFollowsCosine(t *testing.T) {
+	normal := Vector{0.6, 0.8}
+	const runs = 100000
+	const bins = 6
+	var counts [bins]int
+	for i := 0; i < runs; i++ {
+		direction := lambertDirection(normal)
+		if math.Abs(direction.length()-1) > 1e-9 {
+			t.Fatalf("lambertDirection() = %v, not a unit vector", direction)
+		}
+		// Signed angle from the normal, in [-π/2, π/2]
+		theta := math.Atan2(normal.x*direction.y-normal.y*direction.x, dot(normal, direction))
+		bin := int((theta + math.Pi/2) / math.Pi * bins)
+		counts[min(max(bin, 0), bins-1)]++
+	}
+
+	// In 2D the Lambert density is cos θ / 2, so a bin holds half the
+	// difference of the sines at its edges
+	for bin, count := range counts {
+		low := -math.Pi/2 + float64(bin)*math.Pi/bins
+		want := (math.Sin(low+math.Pi/bins) - math.Sin(low)) / 2
+		if got := float64(count) / runs; math.Abs(got-want) > 0.007 {
+			t.Errorf("angles %.0f° to %.0f° from the normal: fraction %v, want %v", low*180/math.Pi, (low+math.Pi/bins)*180/math.Pi, got, want)
+		}
+	}
+}
+
+func TestDiffuseReflectionsStayOnIncidentSide(t *testing.T) {
+	tests := []struct {
+		name string
+		ray  Ray
+	}{
+		{name: "from above", ray: Ray{Vector{5, 2}, Vector{0, -1}}},
+		{name: "from below", ray: Ray{Vector{5, -2}, Vector{0, 1}}},
+		{name: "glancing from above", ray: Ray{Vector{1, 0.5}, Vector{0.995, -0.0995}}},
+		{name: "oblique from below", ray: Ray{Vector{2, -2}, Vector{0.6, 0.8}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				rays := bounceOffWall(WallProperties{roughness: 1}, tt.ray)
+				if len(rays) != 1 {
+					t.Fatalf("a fully rough wall queued %d rays, want 1 diffuse ray", len(rays))
+				}
+				if rays[0].ray.direction.y*tt.ray.direction.y >= 0 {
+					t.Fatalf("diffuse ray leaves along %v, through the wall", rays[0].ray.direction)
+				}
+			}
+		})
+	}
+}
+
+func TestReflectedEnergySplitsByRoughness(t *testing.T) {
+	absorption := bands{0.1, 0.1, 0.2, 0.3, 0.4, 0.5, 0.5}
+	ray := Ray{Vector{2, 2}, Vector{0.6, -0.8}}
+	tests := []struct {
+		name      string
+		roughness float64
+	}{
+		{name: "smooth", roughness: 0},
+		{name: "partly rough", roughness: 0.3},
+		{name: "fully rough", roughness: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rays := bounceOffWall(WallProperties{absorption: absorption, roughness: tt.roughness}, ray)
+			var specular, diffuse bands
+			for _, task := range rays {
+				if task.specular {
+					specular = task.intensity
+					if direction := reflect(ray.direction, Vector{0, 1}); distance(task.ray.direction, direction) > 1e-9 {
+						t.Errorf("specular ray leaves along %v, want %v", task.ray.direction, direction)
+					}
+				} else {
+					diffuse = task.intensity
+				}
+			}
+
+			reflected := absorption.complement()
+			for b := range reflected {
+				if want := reflected[b] * (1 - tt.roughness); math.Abs(specular[b]-want) > 1e-9 {
+					t.Errorf("band %d: specular energy %v, want %v", b, specular[b], want)
+				}
+				if want := reflected[b] * tt.roughness; math.Abs(diffuse[b]-want) > 1e-9 {
+					t.Errorf("band %d: diffuse energy %v, want %v", b, diffuse[b], want)
+				}
+			}
+		})
+	}
+}
+
+func TestTransmittedEnergySplitsByRoughness(t *testing.T) {
+	transparency := bands{0.6, 0.5, 0.4, 0.3, 0.2, 0.1, 0.05}
+	ray := Ray{Vector{2, 2}, Vector{0.6, -0.8}}
+	tests := []struct {
+		name      string
+		roughness float64
+	}{
+		{name: "clear", roughness: 0},
+		{name: "partly diffusing", roughness: 0.4},
+		{name: "fully diffusing", roughness: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rays := bounceOffWall(WallProperties{transparency: transparency, transmissionRoughness: tt.roughness}, ray)
+			var straight, lobe bands
+			for _, task := range rays {
+				switch {
+				case task.reflections > 0:
+					continue
+				case task.specular:
+					straight = task.intensity
+					if distance(task.ray.direction, ray.direction) > 1e-9 {
+						t.Errorf("straight ray leaves along %v, want %v", task.ray.direction, ray.direction)
+					}
+				default:
+					lobe = task.intensity
+				}
+			}
+
+			for b := range transparency {
+				if want := transparency[b] * (1 - tt.roughness); math.Abs(straight[b]-want) > 1e-9 {
+					t.Errorf("band %d: straight-through energy %v, want %v", b, straight[b], want)
+				}
+				if want := transparency[b] * tt.roughness; math.Abs(lobe[b]-want) > 1e-9 {
+					t.Errorf("band %d: transmission lobe energy %v, want %v", b, lobe[b], want)
+				}
+			}
+		})
+	}
+}
+
+func TestTransmissionLobeLeavesOnFarSide(t *testing.T) {
+	tests := []struct {
+		name string
+		ray  Ray
+	}{
+		{name: "from above", ray: Ray{Vector{5, 2}, Vector{0, -1}}},
+		{name: "from below", ray: Ray{Vector{5, -2}, Vector{0, 1}}},
+		{name: "glancing from above", ray: Ray{Vector{1, 0.5}, Vector{0.995, -0.0995}}},
+		{name: "oblique from below", ray: Ray{Vector{2, -2}, Vector{0.6, 0.8}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				rays := bounceOffWall(WallProperties{transparency: uniformBands(1), transmissionRoughness: 1}, tt.ray)
+				if len(rays) != 1 {
+					t.Fatalf("a fully diffusing transparent wall queued %d rays, want 1", len(rays))
+				}
+				if rays[0].ray.direction.y*tt.ray.direction.y <= 0 {
+					t.Fatalf("transmitted ray leaves along %v, back on the incident side", rays[0].ray.direction)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import "math"
+
+// toScreen converts a point in scene coordinates (metres) to screen pixels.
+func (v View) toScreen(p Vector) Vector {
+	return Vector{v.offset.x + p.x*v.pixelsPerMeter, v.offset.y + p.y*v.pixelsPerMeter}
+}
+
+// toWorld converts a point in screen pixels to scene coordinates (metres).
+func (v View) toWorld(p Vector) Vector {
+	return Vector{(p.x - v.offset.x) / v.pixelsPerMeter, (p.y - v.offset.y) / v.pixelsPerMeter}
+}
+
+// bounds returns the part of the scene that is visible on screen.
+func (v View) bounds() aabb {
+	return aabb{min: v.toWorld(Vector{0, 0}), max: v.toWorld(Vector{screenWidth, screenHeight})}
+}
+
+// fitView returns a view that shows the box in the middle of the screen with a
+// margin around it.
+func fitView(box aabb) View {
+	const margin = 0.1
+	size := Vector{math.Max(box.max.x-box.min.x, 1), math.Max(box.max.y-box.min.y, 1)}
+	scale := (1 - 2*margin) * math.Min(screenWidth/size.x, screenHeight/size.y)
+	centre := box.centroid()
+	return View{
+		pixelsPerMeter: scale,
+		offset:         Vector{screenWidth/2 - centre.x*scale, screenHeight/2 - centre.y*scale},
+	}
+}
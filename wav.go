@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// writeWAV writes the channels as an interleaved WAV file with samples in the
+// given format, in the extensible format when there are more than two
+// channels or integer samples wider than 16 bits. channelMask names the
+// speaker each of those channels is for, and is 0 if they aren't for speakers.
+// All channels must have the same length.
+func writeWAV(w io.Writer, rate int, sample sampleFormat, channels [][]float64, channelMask uint32) error {
+	if len(channels) == 0 {
+		return fmt.Errorf("writeWAV: no channels")
+	}
+	frames := len(channels[0])
+	for _, channel := range channels {
+		if len(channel) != frames {
+			return fmt.Errorf("writeWAV: channels have different lengths")
+		}
+	}
+
+	bytesPerSample := sample.bytes()
+	blockAlign := len(channels) * bytesPerSample
+	dataSize := frames * blockAlign
+
+	code := uint16(1) // integer PCM
+	if sample == formatFloat32 {
+		code = 3 // IEEE float
+	}
+	format := []interface{}{
+		code,
+		uint16(len(channels)),
+		uint32(rate),
+		uint32(rate * blockAlign),
+		uint16(blockAlign),
+		uint16(8 * bytesPerSample),
+	}
+	if len(channels) > 2 || sample == formatInt24 {
+		// The extensible format names the sample format by a GUID that
+		// starts with the format code
+		format[0] = uint16(0xFFFE)
+		format = append(format,
+			uint16(22), // size of the extension
+			uint16(8*bytesPerSample),
+			channelMask,
+			[16]byte{byte(code), 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71},
+		)
+	}
+	formatSize := 0
+	for _, field := range format {
+		formatSize += binary.Size(field)
+	}
+
+	bw := bufio.NewWriter(w)
+	header := []interface{}{
+		[4]byte{'R', 'I', 'F', 'F'},
+		uint32(20 + formatSize + dataSize),
+		[4]byte{'W', 'A', 'V', 'E'},
+		[4]byte{'f', 'm', 't', ' '},
+		uint32(formatSize),
+	}
+	header = append(header, format...)
+	header = append(header, [4]byte{'d', 'a', 't', 'a'}, uint32(dataSize))
+	for _, field := range header {
+		if err := binary.Write(bw, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+
+	frame := make([]byte, blockAlign)
+	for i := 0; i < frames; i++ {
+		for ch, channel := range channels {
+			sample.put(frame[ch*bytesPerSample:], channel[i])
+		}
+		if _, err := bw.Write(frame); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// writeWAVFile writes the channels to a new WAV file at path.
+func writeWAVFile(path string, rate int, sample sampleFormat, channels [][]float64, channelMask uint32) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeWAV(f, rate, sample, channels, channelMask); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// exportImpulseResponse writes the current impulse response of a source to a
+// WAV file with a channel per output channel.
+func (g *Game) exportImpulseResponse(source int, path string) error {
+	channels := g.responses[source]
+	if channels == nil {
+		return fmt.Errorf("source %d is switched off", source)
+	}
+	return writeWAVFile(path, sampleRate, formatFloat32, channels, g.channelMask())
+}
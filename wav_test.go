@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestWriteWAVHeader(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeWAV(&buf, sampleRate, formatFloat32, [][]float64{{0, 0.5, -1}, {1, 0, 0.25}}, 0); err != nil {
+		t.Fatalf("writeWAV() error = %v", err)
+	}
+	data := buf.Bytes()
+	if len(data) != 44+3*2*4 {
+		t.Fatalf("writeWAV() wrote %d bytes, want %d", len(data), 44+3*2*4)
+	}
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" || string(data[36:40]) != "data" {
+		t.Errorf("writeWAV() wrote a malformed header %q", data[:44])
+	}
+	if got := binary.LittleEndian.Uint32(data[24:28]); got != sampleRate {
+		t.Errorf("sample rate = %d, want %d", got, sampleRate)
+	}
+	if got := math.Float32frombits(binary.LittleEndian.Uint32(data[44+2*4:])); got != 0.5 {
+		t.Errorf("second left sample = %v, want 0.5", got)
+	}
+
+	if err := writeWAV(&buf, sampleRate, formatFloat32, [][]float64{{0}, {0, 1}}, 0); err == nil {
+		t.Errorf("writeWAV() with mismatched channels returned no error")
+	}
+}